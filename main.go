@@ -1,44 +1,54 @@
 package main
 
 import (
+	"net/http"
+	"os"
 	"runtime"
 
 	log "github.com/sirupsen/logrus"
 )
 
 func main() {
-	log.Info("Starting server on port: ", 8080)
-
-	runtime.GOMAXPROCS(1)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			os.Exit(runVerify(os.Args[2:], os.Stdout))
+		case "dump":
+			os.Exit(runDump(os.Args[2:], os.Stdout))
+		}
+	}
 
-	twr, err := NewTopicWriter("c:\\tmp", "Coco", 2, 20, FlushOnCommit)
+	config, err := ReadConfig()
 	if err != nil {
-		panic(err)
+		log.Fatal("Invalid config: ", err)
 	}
 
-	retChan := twr.WriteWalRecord(&WalRecord{
-		Key:   "Hey",
-		Value: []byte{11, 223, 3},
-	})
-
-	log.Info(<-retChan)
+	dataDir, err := config.DataDir()
+	if err != nil {
+		log.Fatal("Invalid data dir: ", err)
+	}
 
-	retChan = twr.WriteWalRecord(&WalRecord{
-		Key:   "Hey1",
-		Value: []byte{11, 223, 3},
-	})
+	runtime.GOMAXPROCS(1)
 
-	log.Info(<-retChan)
+	topics, err := NewWalTopicManager(dataDir, config.TopicDefaults())
+	if err != nil {
+		log.Fatal("Failed to load topics: ", err)
+	}
+	defer topics.Close()
 
-	retChan = twr.WriteWalRecord(&WalRecord{
-		Key:   "Hey2",
-		Value: []byte{11, 223, 3},
-	})
+	server := NewWalHTTPServer(*config.HTTPServerConfig.Host, *config.HTTPServerConfig.Port, topics)
 
-	log.Info(<-retChan)
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal("Server failed: ", err)
+		}
+	}()
 
 	WaitForCtrlC()
 
-	//time.Sleep(15 * time.Second)
-	defer twr.Close()
+	err = server.Close()
+	if err != nil {
+		log.Warn("Failed to close server: ", err)
+	}
 }
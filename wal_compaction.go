@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//DefaultTombstoneRetention how long compaction keeps tombstones when the topic does not say.
+const DefaultTombstoneRetention = 24 * time.Hour
+
+//walCompactedRecord a record kept by compaction along with its offset.
+type walCompactedRecord struct {
+	offset int64
+	record *WalExRecord
+}
+
+//CompactPartition rewrites the sealed segments of the partition keeping only the newest record of every key.
+// Records with an empty value are tombstones, kept until their timestamp is older than tombstoneRetention so that
+// consumers get to see the delete. Kept records retain their offsets, sequences and timestamps, entries failing the
+// crc check are never dropped. Dropping the last records of the partition does not rewind its sequence, the writer
+// stores it apart on sealing a segment. Returns the number of records removed.
+func CompactPartition(partitionDir Path, tombstoneRetention time.Duration, maxEntrySize int64, now time.Time) (int64, error) {
+	err := removeCompactionLeftovers(partitionDir)
+	if err != nil {
+		return 0, err
+	}
+
+	segments, err := LoadPartitionSegments(partitionDir, maxEntrySize)
+	if err != nil || len(segments) < 2 {
+		return 0, err
+	}
+
+	//The newest offset of every key, the active segment included so that sealed records it overrides go too.
+	latest := make(map[string]int64)
+	for _, s := range segments {
+		start := WalIndexEntry{Offset: s.Index.BaseOffset, Position: 0}
+		_, err := ScanSegment(s.Path, s.Index, start, maxEntrySize, func(offset int64, position int64, wr *WalExRecord, crcOk bool) {
+			if crcOk {
+				latest[wr.Record.Key] = offset
+			}
+		})
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var removed int64
+	for _, s := range segments[:len(segments)-1] {
+		var kept []walCompactedRecord
+		var dropped int64
+
+		start := WalIndexEntry{Offset: s.Index.BaseOffset, Position: 0}
+		end, err := ScanSegment(s.Path, s.Index, start, maxEntrySize, func(offset int64, position int64, wr *WalExRecord, crcOk bool) {
+			tombstone := len(wr.Record.Value) == 0 && now.Sub(time.Unix(0, wr.ID.Timestamp)) > tombstoneRetention
+			if crcOk && (latest[wr.Record.Key] != offset || tombstone) {
+				dropped++
+				return
+			}
+
+			kept = append(kept, walCompactedRecord{offset: offset, record: wr})
+		})
+
+		if err != nil {
+			return removed, err
+		}
+
+		if dropped == 0 {
+			continue
+		}
+
+		err = rewriteSegment(s, kept, end, maxEntrySize)
+		if err != nil {
+			return removed, err
+		}
+
+		log.Info("Compacted segment: ", s.Path, " removed records: ", dropped, " kept: ", len(kept))
+		removed += dropped
+	}
+
+	return removed, nil
+}
+
+//rewriteSegment writes the kept records as a new generation of the segment and deletes the old one.
+// The new generation only shows up once complete, along with its indexes, so readers never see it half written.
+// Readers holding the old generation open keep reading it until they move on to the next segment.
+func rewriteSegment(s *walSegment, kept []walCompactedRecord, end int64, maxEntrySize int64) error {
+	newPath := filepath.Join(filepath.Dir(s.Path), fmt.Sprint(walSegmentStem(s.Name), ".", time.Now().UnixNano(), ".wal"))
+	tmpPath := newPath + ".tmp"
+
+	idx, err := writeCompactedSegment(tmpPath, s.Index.BaseOffset, kept, end)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	timeIndex, _, err := BuildSegmentTimeIndex(tmpPath, idx, maxEntrySize)
+	if err == nil {
+		err = WriteSegmentIndex(newPath, idx)
+	}
+
+	if err == nil {
+		err = WriteSegmentTimeIndex(newPath, timeIndex)
+	}
+
+	if err == nil {
+		err = os.Rename(tmpPath, newPath)
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		os.Remove(SegmentIndexPath(newPath))
+		os.Remove(SegmentTimeIndexPath(newPath))
+		return err
+	}
+
+	return removeSegmentFiles(s.Path)
+}
+
+//writeCompactedSegment writes the records to path and returns the offset index of the file, with entries for every gap
+// in the offsets and for the end of the segment.
+func writeCompactedSegment(path string, baseOffset int64, kept []walCompactedRecord, end int64) (*WalSegmentIndex, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	idx := &WalSegmentIndex{
+		BaseOffset: baseOffset,
+	}
+
+	var position int64
+	next := baseOffset
+	size := []byte{0, 0, 0, 0}
+	for i, r := range kept {
+		if r.offset != next || i%WalIndexInterval == 0 {
+			idx.Entries = append(idx.Entries, WalIndexEntry{Offset: r.offset, Position: position})
+		}
+
+		b, err := r.record.Bytes()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		binary.LittleEndian.PutUint32(size, uint32(len(b)))
+		w.Write(size)
+		w.Write(b)
+
+		position += int64(len(size) + len(b))
+		next = r.offset + 1
+	}
+
+	if next != end {
+		idx.Entries = append(idx.Entries, WalIndexEntry{Offset: end, Position: position})
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return idx, err
+}
+
+//removeSegmentFiles removes a single generation of a segment along with its indexes.
+func removeSegmentFiles(segmentPath string) error {
+	for _, p := range []string{segmentPath, SegmentIndexPath(segmentPath), SegmentTimeIndexPath(segmentPath)} {
+		err := os.Remove(p)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//removeCompactionLeftovers removes what an interrupted compaction left behind: generations superseded by a newer one,
+// unfinished segment files and the indexes written for them.
+func removeCompactionLeftovers(partitionDir Path) error {
+	all, err := listWalFiles(partitionDir.String())
+	if err != nil {
+		return err
+	}
+
+	current, err := ListWalSegments(partitionDir.String())
+	if err != nil {
+		return err
+	}
+
+	latest := make(map[string]bool, len(current))
+	for _, name := range current {
+		latest[name] = true
+	}
+
+	for _, name := range all {
+		if !latest[name] {
+			log.Info("Removing superseded segment: ", partitionDir.Add(name))
+			err = removeSegmentFiles(partitionDir.Add(name).String())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	unfinished, err := filepath.Glob(partitionDir.Add("*.wal.tmp").String())
+	if err != nil {
+		return err
+	}
+
+	for _, tmp := range unfinished {
+		log.Info("Removing unfinished compaction of: ", tmp)
+		err = removeSegmentFiles(strings.TrimSuffix(tmp, ".tmp"))
+		if err == nil {
+			err = os.Remove(tmp)
+		}
+
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
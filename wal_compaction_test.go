@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCompactionKeepsNewestRecordPerKey(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	twr, err := NewTopicWriter(dir, "Test", 1, 120, 0, NoFlush, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer twr.Close()
+
+	//Offsets 0..11 cycle over three keys, offset 12 deletes key c, key d is written until the segment rolls over.
+	keys := []string{"a", "b", "c"}
+	count := 12
+	for i := 0; i < count; i++ {
+		err := <-twr.WriteWalRecord(&WalRecord{Key: keys[i%3], Value: []byte(fmt.Sprint(i))})
+		if err != nil {
+			t.Error("Failed to write record: ", err)
+			return
+		}
+	}
+
+	<-twr.WriteWalRecord(&WalRecord{Key: "c", Value: []byte{}})
+	for {
+		segments, _ := ListWalSegments(dir.Add("Test").AddUint32(0).String())
+		err := <-twr.WriteWalRecord(&WalRecord{Key: "d", Value: []byte("active")})
+		if err != nil {
+			t.Error("Failed to write record: ", err)
+			return
+		}
+
+		after, _ := ListWalSegments(dir.Add("Test").AddUint32(0).String())
+		if len(after) > len(segments) {
+			break
+		}
+	}
+
+	partitionDir := dir.Add("Test").AddUint32(0)
+	reader := twr.NewReader()
+	end, _ := reader.EndOffset(0)
+
+	old, err := reader.Partition(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	first, err := old.Next()
+	if err != nil || first.Offset != 0 {
+		t.Error("Failed to read the first record: ", first, " ", err)
+		return
+	}
+
+	removed, err := CompactPartition(partitionDir, time.Hour, 0, time.Now())
+	if err != nil || removed == 0 {
+		t.Error("Expected records to be removed but found: ", removed, " ", err)
+		return
+	}
+
+	//The cursor opened before compaction keeps reading what it had open, then moves on without repeating offsets.
+	prev := first.Offset
+	for {
+		e, err := old.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil || e.Offset <= prev {
+			t.Error("Unexpected entry from the cursor opened before compaction: ", e, " ", err)
+			return
+		}
+
+		prev = e.Offset
+	}
+
+	newEnd, _ := reader.EndOffset(0)
+	if newEnd != end {
+		t.Error("Expected the end offset to stay at ", end, " but found: ", newEnd)
+		return
+	}
+
+	cursor, err := reader.Partition(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cursor.Close()
+
+	var kept []*WalPartitionEntry
+	for {
+		e, err := cursor.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Error("Failed to read compacted partition: ", err)
+			return
+		}
+
+		kept = append(kept, e)
+	}
+
+	if len(kept) < 4 || kept[0].Offset != 9 || string(kept[0].Record.Record.Value) != "9" ||
+		kept[1].Offset != 10 || kept[2].Offset != 12 || len(kept[2].Record.Record.Value) != 0 || kept[3].Offset != end-1 {
+		t.Error("Unexpected records after compaction: ", len(kept))
+		for _, e := range kept {
+			t.Log(e.Offset, " ", e.Record.Record.Key, " ", string(e.Record.Record.Value))
+		}
+		return
+	}
+
+	for _, e := range kept {
+		if e.Record.ID.Sequence != uint64(e.Offset+1) || !e.CrcOk {
+			t.Error("Expected the original sequence and crc at offset: ", e.Offset, " but found: ", e.Record.ID.Sequence)
+			return
+		}
+	}
+
+	err = cursor.SeekTo(11)
+	if err != nil {
+		t.Error("Failed to seek to a removed offset: ", err)
+		return
+	}
+
+	e, err := cursor.Next()
+	if err != nil || e.Offset != 12 {
+		t.Error("Expected the next kept offset after a removed one but found: ", e, " ", err)
+		return
+	}
+
+	since, err := reader.OffsetForTime(0, kept[1].Record.ID.Timestamp)
+	if err != nil || since != 10 {
+		t.Error("Unexpected offset for time after compaction: ", since, " ", err)
+		return
+	}
+
+	report, err := VerifyPartition(dir.Add("Test"), "Test", 0, 0)
+	if err != nil || len(report.Issues) != 0 {
+		t.Error("Expected a compacted partition to verify cleanly: ", err, " ", report)
+		return
+	}
+
+	removed, err = CompactPartition(partitionDir, time.Hour, 0, time.Now().Add(2*time.Hour))
+	if err != nil || removed != 1 {
+		t.Error("Expected only the expired tombstone to go but found: ", removed, " ", err)
+		return
+	}
+
+	err = cursor.SeekTo(0)
+	if err == nil {
+		e, err = cursor.Next()
+	}
+
+	if err != nil || e.Offset != 9 {
+		t.Error("Unexpected first record after the second compaction: ", e, " ", err)
+		return
+	}
+
+	all, _ := listWalFiles(partitionDir.String())
+	segments, _ := ListWalSegments(partitionDir.String())
+	if len(all) != len(segments) {
+		t.Error("Expected superseded generations to be gone: ", all, " ", segments)
+		return
+	}
+
+	err = <-twr.WriteWalRecord(&WalRecord{Key: "a", Value: []byte("after")})
+	if err != nil {
+		t.Error("Failed to write after compaction: ", err)
+	}
+}
+
+func TestCompactionKeepsSequencesUnique(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	//Smaller than a record, every segment is full after one.
+	twr, err := NewTopicWriter(dir, "Test", 1, 30, 0, NoFlush, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte("v")})
+	<-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte{}})
+	twr.Close()
+
+	//Reopened with a full newest segment, the partition starts an empty one and the expired tombstone goes.
+	twr, err = NewTopicWriter(dir, "Test", 1, 30, 0, NoFlush, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := CompactPartition(dir.Add("Test").AddUint32(0), 0, 0, time.Now().Add(time.Hour))
+	twr.Close()
+	if err != nil || removed != 2 {
+		t.Error("Expected both records to be removed but found: ", removed, " ", err)
+		return
+	}
+
+	twr, err = NewTopicWriter(dir, "Test", 1, 30, 0, NoFlush, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer twr.Close()
+
+	id, retChan := twr.WriteWalRecordWithID(&WalRecord{Key: "k", Value: []byte("w")})
+	if err := <-retChan; err != nil || id.Sequence != 3 {
+		t.Error("Expected the sequence to continue after compaction but found: ", id.Sequence, " ", err)
+	}
+}
+
+func TestCompactionLeftoversAreRemoved(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	os.MkdirAll(dir.String(), os.ModePerm)
+	for _, name := range []string{"100.wal", "100.5.wal", "100.5.index", "100.7.wal", "100.7.index", "200.9.wal.tmp", "200.9.index", "200.wal"} {
+		f, _ := os.Create(dir.Add(name).String())
+		f.Close()
+	}
+
+	segments, _ := ListWalSegments(dir.String())
+	if len(segments) != 2 || segments[0] != "100.7.wal" || segments[1] != "200.wal" {
+		t.Error("Expected the newest generation of every segment but found: ", segments)
+		return
+	}
+
+	err := removeCompactionLeftovers(dir)
+	if err != nil {
+		t.Error("Failed to remove leftovers: ", err)
+		return
+	}
+
+	for _, name := range []string{"100.wal", "100.5.wal", "100.5.index", "200.9.wal.tmp", "200.9.index"} {
+		if _, err := os.Stat(dir.Add(name).String()); !os.IsNotExist(err) {
+			t.Error("Expected leftover to be removed: ", name)
+		}
+	}
+
+	for _, name := range []string{"100.7.wal", "100.7.index", "200.wal"} {
+		if _, err := os.Stat(dir.Add(name).String()); err != nil {
+			t.Error("Expected file to be kept: ", name, " ", err)
+		}
+	}
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/gdexlab/go-render/render"
 	log "github.com/sirupsen/logrus"
@@ -19,44 +23,175 @@ func init() {
 	log.SetReportCaller(true)
 }
 
-//Config is a configuration struct.
+const megabyte = 1024 * 1024
+
+//Config is a configuration struct. Sizes are expressed in megabytes.
 type Config struct {
 	HTTPServerConfig struct {
 		Port *int    `json:"port"`
 		Host *string `json:"host"`
 	} `json:"server"`
 	LogFile struct {
-		DefaultLogBehaviour   *string `json:"defaultLogBehaviour"`
-		MaxLogFileSize        *int    `json:"maxLogFileSize"`
-		MaxLogEntrySize       *int    `json:"maxLogEntrySize"`
-		LogFlushTimeoutMillis *int    `json:"logFlushTimeoutMillis"`
+		DataDir                      *string `json:"dataDir"`
+		DefaultLogBehaviour          *string `json:"defaultLogBehaviour"`
+		MaxLogFileSize               *int    `json:"maxLogFileSize"`
+		MaxLogEntrySize              *int    `json:"maxLogEntrySize"`
+		MaxBatchSize                 *int    `json:"maxBatchSize"`
+		LogFlushTimeoutMillis        *int    `json:"logFlushTimeoutMillis"`
+		QuarantineCorruptedTail      *bool   `json:"quarantineCorruptedTail"`
+		RetentionCheckIntervalMillis *int    `json:"retentionCheckIntervalMillis"`
 	} `json:"logFile"`
 }
 
-//ReadConfig reads config from a file.
-func ReadConfig() *Config {
-	ret := &Config{}
-	return ret.readFile()
-}
-
-func (c *Config) readFile() *Config {
+//ReadConfig reads config from the file given with -config, fills in defaults for missing values and validates it.
+func ReadConfig() (*Config, error) {
 	configFilePath := flag.String("config", "config.json", "Provide a config file.")
 	flag.Parse()
 
-	f, err := os.Open(*configFilePath)
+	ret := &Config{}
+	err := ret.readFile(*configFilePath)
 	if err != nil {
+		return nil, err
+	}
+
+	ret.setDefaults()
+	log.Debug("Config values: ", render.Render(ret))
+
+	return ret, ret.Validate()
+}
+
+func (c *Config) readFile(configFilePath string) error {
+	f, err := os.Open(configFilePath)
+	if os.IsNotExist(err) {
 		log.Warn("Could not find config file, setting defaults. ", err)
-		return c
+		return nil
+	} else if err != nil {
+		return err
 	}
 	defer f.Close()
 
 	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
 	err = decoder.Decode(c)
 	if err != nil {
-		log.Warn("Could not find config file, setting defaults. ", err)
+		return fmt.Errorf("Could not parse config file %s: %v", configFilePath, err)
+	}
+
+	return nil
+}
+
+func (c *Config) setDefaults() {
+	if c.HTTPServerConfig.Host == nil {
+		host := "localhost"
+		c.HTTPServerConfig.Host = &host
 	}
 
-	log.Debug("Config values: ", render.Render(c))
+	if c.HTTPServerConfig.Port == nil {
+		port := 8080
+		c.HTTPServerConfig.Port = &port
+	}
+
+	if c.LogFile.DataDir == nil {
+		dataDir := "data"
+		c.LogFile.DataDir = &dataDir
+	}
+
+	if c.LogFile.DefaultLogBehaviour == nil {
+		behaviour := string(FlushOnCommit)
+		c.LogFile.DefaultLogBehaviour = &behaviour
+	}
+
+	if c.LogFile.MaxLogFileSize == nil {
+		size := 16
+		c.LogFile.MaxLogFileSize = &size
+	}
+
+	if c.LogFile.MaxLogEntrySize == nil {
+		size := 1
+		c.LogFile.MaxLogEntrySize = &size
+	}
+
+	if c.LogFile.MaxBatchSize == nil {
+		size := *c.LogFile.MaxLogFileSize
+		c.LogFile.MaxBatchSize = &size
+	}
+
+	if c.LogFile.LogFlushTimeoutMillis == nil {
+		timeout := 0
+		c.LogFile.LogFlushTimeoutMillis = &timeout
+	}
+
+	if c.LogFile.QuarantineCorruptedTail == nil {
+		quarantine := false
+		c.LogFile.QuarantineCorruptedTail = &quarantine
+	}
 
-	return c
+	if c.LogFile.RetentionCheckIntervalMillis == nil {
+		interval := 60000
+		c.LogFile.RetentionCheckIntervalMillis = &interval
+	}
+}
+
+//Validate rejects nonsense values. Expects defaults to be set.
+func (c *Config) Validate() error {
+	if *c.HTTPServerConfig.Port <= 0 || *c.HTTPServerConfig.Port > 65535 {
+		return fmt.Errorf("Invalid server port: %d", *c.HTTPServerConfig.Port)
+	}
+
+	if *c.HTTPServerConfig.Host == "" {
+		return errors.New("Server host must not be empty.")
+	}
+
+	if *c.LogFile.DataDir == "" {
+		return errors.New("Data dir must not be empty.")
+	}
+
+	if !WalSyncType(*c.LogFile.DefaultLogBehaviour).Valid() {
+		return fmt.Errorf("Invalid default log behaviour: %s, expected %s or %s", *c.LogFile.DefaultLogBehaviour, FlushOnCommit, NoFlush)
+	}
+
+	if *c.LogFile.MaxLogFileSize <= 0 {
+		return fmt.Errorf("Invalid max log file size: %d", *c.LogFile.MaxLogFileSize)
+	}
+
+	if *c.LogFile.MaxLogEntrySize <= 0 || *c.LogFile.MaxLogEntrySize > *c.LogFile.MaxLogFileSize {
+		return fmt.Errorf("Invalid max log entry size: %d, must be between 1 and max log file size", *c.LogFile.MaxLogEntrySize)
+	}
+
+	if *c.LogFile.MaxBatchSize < *c.LogFile.MaxLogEntrySize {
+		return fmt.Errorf("Invalid max batch size: %d, must be at least the max log entry size", *c.LogFile.MaxBatchSize)
+	}
+
+	if *c.LogFile.LogFlushTimeoutMillis < 0 {
+		return fmt.Errorf("Invalid log flush timeout: %d", *c.LogFile.LogFlushTimeoutMillis)
+	}
+
+	if *c.LogFile.RetentionCheckIntervalMillis < 0 {
+		return fmt.Errorf("Invalid retention check interval: %d", *c.LogFile.RetentionCheckIntervalMillis)
+	}
+
+	return nil
+}
+
+//DataDir returns the absolute path of the data directory.
+func (c *Config) DataDir() (Path, error) {
+	dir, err := filepath.Abs(*c.LogFile.DataDir)
+	if err != nil {
+		return "", err
+	}
+
+	return Path(dir), nil
+}
+
+//TopicDefaults returns the settings applied to every topic that does not override them.
+func (c *Config) TopicDefaults() WalTopicDefaults {
+	return WalTopicDefaults{
+		MaxSegmentSize:         int64(*c.LogFile.MaxLogFileSize) * megabyte,
+		MaxRecordSize:          int64(*c.LogFile.MaxLogEntrySize) * megabyte,
+		MaxBatchSize:           int64(*c.LogFile.MaxBatchSize) * megabyte,
+		WalSyncType:            WalSyncType(*c.LogFile.DefaultLogBehaviour),
+		FlushTimeout:           time.Duration(*c.LogFile.LogFlushTimeoutMillis) * time.Millisecond,
+		Quarantine:             *c.LogFile.QuarantineCorruptedTail,
+		RetentionCheckInterval: time.Duration(*c.LogFile.RetentionCheckIntervalMillis) * time.Millisecond,
+	}
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigDefaultsAreValid(t *testing.T) {
+	c := &Config{}
+	c.setDefaults()
+
+	err := c.Validate()
+	if err != nil {
+		t.Error("Defaults should be valid: ", err)
+		return
+	}
+
+	defaults := c.TopicDefaults()
+	if defaults.MaxSegmentSize != 16*megabyte || defaults.MaxRecordSize != megabyte || defaults.MaxBatchSize != 16*megabyte || defaults.WalSyncType != FlushOnCommit || defaults.FlushTimeout != 0 {
+		t.Error("Unexpected topic defaults: ", defaults)
+	}
+}
+
+func TestConfigRejectsNonsense(t *testing.T) {
+	negative := -1
+	zero := 0
+	tooBig := 100
+	behaviour := "Sometimes"
+
+	for name, set := range map[string]func(c *Config){
+		"port":      func(c *Config) { c.HTTPServerConfig.Port = &negative },
+		"segment":   func(c *Config) { c.LogFile.MaxLogFileSize = &zero },
+		"entry":     func(c *Config) { c.LogFile.MaxLogEntrySize = &tooBig },
+		"batch":     func(c *Config) { c.LogFile.MaxBatchSize = &zero },
+		"timeout":   func(c *Config) { c.LogFile.LogFlushTimeoutMillis = &negative },
+		"behaviour": func(c *Config) { c.LogFile.DefaultLogBehaviour = &behaviour },
+		"retention": func(c *Config) { c.LogFile.RetentionCheckIntervalMillis = &negative },
+	} {
+		c := &Config{}
+		set(c)
+		c.setDefaults()
+
+		if c.Validate() == nil {
+			t.Error("Expected validation to fail for: ", name)
+		}
+	}
+
+	timeout := 200
+	c := &Config{}
+	c.LogFile.LogFlushTimeoutMillis = &timeout
+	c.setDefaults()
+	if c.TopicDefaults().FlushTimeout != 200*time.Millisecond {
+		t.Error("Unexpected flush timeout: ", c.TopicDefaults().FlushTimeout)
+	}
+}
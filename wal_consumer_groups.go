@@ -0,0 +1,497 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//consumerOffsetsTopic is the internal compacted topic holding the offsets committed by consumer groups.
+const consumerOffsetsTopic = "__consumer_offsets"
+
+//Offset reset targets.
+const (
+	ResetToEarliest  = "earliest"
+	ResetToLatest    = "latest"
+	ResetToTimestamp = "timestamp"
+	ResetToOffset    = "offset"
+)
+
+//WalGroupOffset the offset a consumer group committed for a partition, the offset of the next record it is going to read.
+type WalGroupOffset struct {
+	Group     string `json:"group"`
+	Topic     string `json:"topic"`
+	Partition uint32 `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Metadata  string `json:"metadata,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+
+	//sequence orders the commit in the offsets topic.
+	sequence uint64
+}
+
+//WalOffsetCommit an offset to commit for a partition of a topic.
+type WalOffsetCommit struct {
+	Partition uint32
+	Offset    int64
+	Metadata  string
+}
+
+//WalOffsetReset where to move the offsets of a group to. Timestamp is used with ResetToTimestamp and Offset with ResetToOffset.
+type WalOffsetReset struct {
+	To        string
+	Timestamp int64
+	Offset    int64
+}
+
+//WalConsumerGroups keeps the offsets committed by consumer groups and coordinates their members. Every commit is
+// appended to the internal offsets topic before it becomes visible, the latest commits are read back from it on startup.
+type WalConsumerGroups struct {
+	mutex       sync.RWMutex
+	topics      *WalTopicManager
+	writer      *WalTopicWriter
+	offsets     map[string]*WalGroupOffset
+	states      map[string]*walGroupState
+	commitLocks map[string]*sync.Mutex
+	memberCount int64
+	now         func() time.Time
+
+	//written is called once the offsets of a commit got written, before they are checked again.
+	written func()
+}
+
+func groupOffsetKey(group string, topic string, partition uint32) string {
+	return fmt.Sprint(group, "/", topic, "/", partition)
+}
+
+//newWalConsumerGroups loads the committed offsets from the offsets topic written by writer.
+func newWalConsumerGroups(topics *WalTopicManager, writer *WalTopicWriter) (*WalConsumerGroups, error) {
+	ret := &WalConsumerGroups{
+		topics:      topics,
+		writer:      writer,
+		offsets:     make(map[string]*WalGroupOffset),
+		states:      make(map[string]*walGroupState),
+		commitLocks: make(map[string]*sync.Mutex),
+		now:         time.Now,
+		written:     func() {},
+	}
+
+	reader := writer.NewReader()
+	var p uint32
+	for p = 0; p < writer.PartitionCount(); p++ {
+		start, err := reader.StartOffset(p)
+		if err != nil {
+			return nil, err
+		}
+
+		cursor, err := reader.Partition(p, start)
+		if err != nil {
+			return nil, err
+		}
+
+		err = ret.load(cursor)
+		cursor.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Info("Loaded committed offsets: ", len(ret.offsets))
+	return ret, nil
+}
+
+func (g *WalConsumerGroups) load(cursor *WalPartitionCursor) error {
+	for {
+		e, err := cursor.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if !e.CrcOk {
+			log.Warn("Skipping committed offset failing the crc check at: ", e.Offset)
+			continue
+		}
+
+		if len(e.Record.Record.Value) == 0 {
+			delete(g.offsets, e.Record.Record.Key)
+			continue
+		}
+
+		o := &WalGroupOffset{sequence: e.Record.ID.Sequence}
+		err = json.Unmarshal(e.Record.Record.Value, o)
+		if err != nil {
+			log.Warn("Skipping unreadable committed offset at: ", e.Offset, " ", err)
+			continue
+		}
+
+		g.offsets[e.Record.Record.Key] = o
+	}
+}
+
+//Commit persists the offset of the group for the topic partition. Groups with members only accept commits from the
+// member the partition is assigned to in the current generation, which fences out members that missed a rebalance.
+func (g *WalConsumerGroups) Commit(group string, gen WalGroupGeneration, topic string, partition uint32, offset int64, metadata string) (*WalGroupOffset, error) {
+	ret, err := g.CommitOffsets(group, gen, topic, []WalOffsetCommit{{Partition: partition, Offset: offset, Metadata: metadata}})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret[0], nil
+}
+
+//CommitOffsets persists the offsets of the group for partitions of the topic like Commit, all of them or none.
+func (g *WalConsumerGroups) CommitOffsets(group string, gen WalGroupGeneration, topic string, commits []WalOffsetCommit) ([]*WalGroupOffset, error) {
+	twr, err := g.validate(group, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.commit(twr, group, topic, commits, func() error {
+		for _, c := range commits {
+			err := g.checkGeneration(group, gen, topic, c.Partition)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+//commitLock returns the lock serializing the commits of the group from their checks until they are applied.
+func (g *WalConsumerGroups) commitLock(group string) *sync.Mutex {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	lock, ok := g.commitLocks[group]
+	if !ok {
+		lock = &sync.Mutex{}
+		g.commitLocks[group] = lock
+	}
+
+	return lock
+}
+
+//commit appends the offsets to the offsets topic as one batch and makes them visible once written. The lock is not
+// held while writing, so check runs under it both before the write and before the offsets are applied, a rebalance or
+// a delete of the topic may have happened meanwhile. Offsets failing the second check are overwritten in the offsets
+// topic with the ones visible, they must not come back on restart.
+func (g *WalConsumerGroups) commit(twr *WalTopicWriter, group string, topic string, commits []WalOffsetCommit, check func() error) ([]*WalGroupOffset, error) {
+	if len(commits) == 0 {
+		return nil, NewWalError(ErrInvalidGroupRequest, "No offsets to commit.")
+	}
+
+	now := time.Now().UnixNano()
+	ret := make([]*WalGroupOffset, len(commits))
+	records := make([]*WalRecord, len(commits))
+	for i, c := range commits {
+		if c.Partition >= twr.PartitionCount() {
+			return nil, NewWalError(ErrInvalidGroupRequest, fmt.Sprint("No such partition: ", c.Partition))
+		}
+
+		if c.Offset < 0 {
+			return nil, NewWalError(ErrInvalidGroupRequest, fmt.Sprint("Invalid offset: ", c.Offset))
+		}
+
+		ret[i] = &WalGroupOffset{
+			Group:     group,
+			Topic:     topic,
+			Partition: c.Partition,
+			Offset:    c.Offset,
+			Metadata:  c.Metadata,
+			Timestamp: now,
+		}
+
+		value, err := json.Marshal(ret[i])
+		if err != nil {
+			return nil, err
+		}
+
+		records[i] = &WalRecord{Key: groupOffsetKey(group, topic, c.Partition), Value: value}
+	}
+
+	lock := g.commitLock(group)
+	lock.Lock()
+	defer lock.Unlock()
+
+	g.mutex.Lock()
+	err := g.checkCommit(twr, topic, check)
+	g.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	ids, retChan := g.writer.WriteWalRecords(records)
+	for _, err := range <-retChan {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	g.written()
+
+	g.mutex.Lock()
+	err = g.checkCommit(twr, topic, check)
+	if err == nil {
+		for i, o := range ret {
+			o.sequence = ids[i].Sequence
+			g.apply(records[i].Key, o)
+		}
+
+		g.mutex.Unlock()
+		return ret, nil
+	}
+
+	//Nothing else writes the offsets of the group while its commit lock is held, the visible ones stay as they are.
+	deleted := g.topics.Topic(topic) != twr
+	for _, r := range records {
+		r.Value = nil
+		if current, ok := g.offsets[r.Key]; ok && !deleted {
+			r.Value, _ = json.Marshal(current)
+		}
+	}
+	g.mutex.Unlock()
+
+	log.Warn("Discarding offsets of group: ", group, " topic: ", topic, " ", err)
+	ids, retChan = g.writer.WriteWalRecords(records)
+	for _, werr := range <-retChan {
+		if werr != nil {
+			log.Error("Failed to overwrite discarded offsets of group: ", group, " topic: ", topic, " ", werr)
+			return nil, err
+		}
+	}
+
+	g.mutex.Lock()
+	for i, r := range records {
+		if current, ok := g.offsets[r.Key]; ok {
+			if r.Value == nil {
+				delete(g.offsets, r.Key)
+			} else {
+				current.sequence = ids[i].Sequence
+			}
+		}
+	}
+	g.mutex.Unlock()
+
+	return nil, err
+}
+
+//checkCommit rejects commits for a topic deleted since they got validated, then runs check. Expects the lock to be held.
+func (g *WalConsumerGroups) checkCommit(twr *WalTopicWriter, topic string, check func() error) error {
+	if g.topics.Topic(topic) != twr {
+		return NewWalError(ErrTopicNotFound, fmt.Sprint("No such topic: ", topic))
+	}
+
+	return check()
+}
+
+//apply makes the offset visible unless a commit written after it already is. Expects the lock to be held.
+func (g *WalConsumerGroups) apply(key string, o *WalGroupOffset) {
+	if current, ok := g.offsets[key]; ok && current.sequence > o.sequence {
+		return
+	}
+
+	g.offsets[key] = o
+}
+
+//topicDeleted removes the offsets committed for the topic, writing tombstones so that they do not come back when a
+// topic with the same name gets created, and rebalances the groups reading it. The commit locks of the groups are held
+// meanwhile, commits checked after get rejected as the topic is gone.
+func (g *WalConsumerGroups) topicDeleted(topic string) error {
+	g.mutex.RLock()
+	seen := make(map[string]bool)
+	groups := make([]string, 0)
+	for _, o := range g.offsets {
+		if o.Topic == topic && !seen[o.Group] {
+			seen[o.Group] = true
+			groups = append(groups, o.Group)
+		}
+	}
+	g.mutex.RUnlock()
+
+	//Always locked in the same order, concurrent deletes do not deadlock.
+	sort.Strings(groups)
+	for _, group := range groups {
+		lock := g.commitLock(group)
+		lock.Lock()
+		defer lock.Unlock()
+	}
+
+	g.mutex.RLock()
+	var records []*WalRecord
+	for key, o := range g.offsets {
+		if o.Topic == topic {
+			records = append(records, &WalRecord{Key: key})
+		}
+	}
+	g.mutex.RUnlock()
+
+	if len(records) > 0 {
+		ids, retChan := g.writer.WriteWalRecords(records)
+		for _, err := range <-retChan {
+			if err != nil {
+				return err
+			}
+		}
+
+		g.mutex.Lock()
+		for i, r := range records {
+			if current, ok := g.offsets[r.Key]; ok && current.sequence < ids[i].Sequence {
+				delete(g.offsets, r.Key)
+			}
+		}
+		g.mutex.Unlock()
+
+		log.Info("Removed committed offsets of deleted topic: ", topic, " count: ", len(records))
+	}
+
+	g.topicResized(topic)
+	return nil
+}
+
+//Offset returns the offset committed by the group for the topic partition.
+func (g *WalConsumerGroups) Offset(group string, topic string, partition uint32) (*WalGroupOffset, bool) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	o, ok := g.offsets[groupOffsetKey(group, topic, partition)]
+	return o, ok
+}
+
+//Offsets returns the offsets committed by the group sorted by topic and partition, only those of topic unless empty.
+func (g *WalConsumerGroups) Offsets(group string, topic string) []*WalGroupOffset {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	ret := make([]*WalGroupOffset, 0)
+	for _, o := range g.offsets {
+		if o.Group == group && (topic == "" || o.Topic == topic) {
+			ret = append(ret, o)
+		}
+	}
+
+	sort.Slice(ret, func(i1, i2 int) bool {
+		if ret[i1].Topic != ret[i2].Topic {
+			return ret[i1].Topic < ret[i2].Topic
+		}
+
+		return ret[i1].Partition < ret[i2].Partition
+	})
+
+	return ret
+}
+
+//committedTopics returns the names of the topics having committed offsets.
+func (g *WalConsumerGroups) committedTopics() []string {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	seen := make(map[string]bool)
+	ret := make([]string, 0)
+	for _, o := range g.offsets {
+		if !seen[o.Topic] {
+			seen[o.Topic] = true
+			ret = append(ret, o.Topic)
+		}
+	}
+
+	return ret
+}
+
+//Groups returns the names of the groups having committed offsets or members, sorted.
+func (g *WalConsumerGroups) Groups() []string {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	seen := make(map[string]bool)
+	ret := make([]string, 0)
+	for _, o := range g.offsets {
+		if !seen[o.Group] {
+			seen[o.Group] = true
+			ret = append(ret, o.Group)
+		}
+	}
+
+	for group, state := range g.states {
+		if !seen[group] && len(state.members) > 0 {
+			seen[group] = true
+			ret = append(ret, group)
+		}
+	}
+
+	sort.Strings(ret)
+	return ret
+}
+
+//Reset commits new offsets for the group on every partition of the topic, or only on partition when not nil.
+// Only groups without members can be reset.
+func (g *WalConsumerGroups) Reset(group string, topic string, partition *uint32, reset WalOffsetReset) ([]*WalGroupOffset, error) {
+	twr, err := g.validate(group, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	count := twr.PartitionCount()
+	if partition != nil && *partition >= count {
+		return nil, NewWalError(ErrInvalidGroupRequest, fmt.Sprint("No such partition: ", *partition))
+	}
+
+	reader := twr.NewReader()
+	commits := make([]WalOffsetCommit, 0)
+	var p uint32
+	for p = 0; p < count; p++ {
+		if partition != nil && p != *partition {
+			continue
+		}
+
+		var offset int64
+		switch reset.To {
+		case ResetToEarliest:
+			offset, err = reader.StartOffset(p)
+		case ResetToLatest:
+			offset, err = reader.EndOffset(p)
+		case ResetToTimestamp:
+			offset, err = reader.OffsetForTime(p, reset.Timestamp)
+		case ResetToOffset:
+			offset = reset.Offset
+		default:
+			err = NewWalError(ErrInvalidGroupRequest, fmt.Sprint("Invalid reset target: ", reset.To))
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		commits = append(commits, WalOffsetCommit{Partition: p, Offset: offset})
+	}
+
+	return g.commit(twr, group, topic, commits, func() error {
+		members := len(g.groupState(group).members)
+		if members > 0 {
+			return NewWalError(ErrGroupNotEmpty, fmt.Sprint("Group ", group, " has members: ", members))
+		}
+
+		return nil
+	})
+}
+
+func (g *WalConsumerGroups) validate(group string, topic string) (*WalTopicWriter, error) {
+	if !topicNamePattern.MatchString(group) {
+		return nil, NewWalError(ErrInvalidGroupRequest, fmt.Sprint("Invalid group name: ", group))
+	}
+
+	twr := g.topics.Topic(topic)
+	if twr == nil || strings.HasPrefix(topic, internalTopicPrefix) {
+		return nil, NewWalError(ErrTopicNotFound, fmt.Sprint("No such topic: ", topic))
+	}
+
+	return twr, nil
+}
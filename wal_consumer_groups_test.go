@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConsumerGroupOffsetsSurviveRestart(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	topics, err := NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = topics.CreateTopic(WalTopicConfig{Name: "Test", PartitionCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := topics.Groups()
+	for i, offset := range []int64{3, 7, 5} {
+		_, err := groups.Commit("workers", WalGroupGeneration{}, "Test", uint32(i%2), offset, fmt.Sprint("commit ", i))
+		if err != nil {
+			t.Error("Failed to commit offset: ", err)
+			return
+		}
+	}
+
+	_, err = groups.Commit("workers", WalGroupGeneration{}, "Missing", 0, 1, "")
+	if we, ok := err.(WalError); !ok || we.Code() != ErrTopicNotFound {
+		t.Error("Expected topic not found but found: ", err)
+		return
+	}
+
+	for _, commit := range []func() error{
+		func() error { _, err := groups.Commit("workers", WalGroupGeneration{}, "Test", 2, 1, ""); return err },
+		func() error { _, err := groups.Commit("workers", WalGroupGeneration{}, "Test", 0, -1, ""); return err },
+		func() error { _, err := groups.Commit("bad/group", WalGroupGeneration{}, "Test", 0, 1, ""); return err },
+	} {
+		if we, ok := commit().(WalError); !ok || we.Code() != ErrInvalidGroupRequest {
+			t.Error("Expected an invalid group request but found: ", we)
+		}
+	}
+
+	topics.Close()
+
+	topics, err = NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer topics.Close()
+
+	offsets := topics.Groups().Offsets("workers", "Test")
+	if len(offsets) != 2 || offsets[0].Offset != 5 || offsets[0].Metadata != "commit 2" || offsets[1].Offset != 7 {
+		t.Error("Unexpected offsets after restart: ", offsets)
+		return
+	}
+
+	if groups := topics.Groups().Groups(); len(groups) != 1 || groups[0] != "workers" {
+		t.Error("Unexpected groups after restart: ", groups)
+		return
+	}
+
+	if configs := topics.Topics(); len(configs) != 1 || configs[0].Name != "Test" {
+		t.Error("Expected the offsets topic to be hidden but found: ", configs)
+	}
+}
+
+func TestConsumerGroupReset(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	topics, err := NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer topics.Close()
+
+	twr, err := topics.CreateTopic(WalTopicConfig{Name: "Test", PartitionCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []*WalRecordID
+	for i := 0; i < 4; i++ {
+		id, retChan := twr.WriteWalRecordWithID(&WalRecord{Key: "k", Value: []byte(fmt.Sprint(i))})
+		if err := <-retChan; err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, id)
+		time.Sleep(time.Millisecond)
+	}
+
+	partition := uint32(0)
+	for _, tc := range []struct {
+		reset    WalOffsetReset
+		expected int64
+	}{
+		{WalOffsetReset{To: ResetToLatest}, 4},
+		{WalOffsetReset{To: ResetToEarliest}, 0},
+		{WalOffsetReset{To: ResetToTimestamp, Timestamp: ids[2].Timestamp}, 2},
+		{WalOffsetReset{To: ResetToOffset, Offset: 1}, 1},
+	} {
+		offsets, err := topics.Groups().Reset("workers", "Test", &partition, tc.reset)
+		if err != nil || len(offsets) != 1 || offsets[0].Offset != tc.expected {
+			t.Error("Unexpected reset to: ", tc.reset.To, " ", offsets, " ", err)
+			return
+		}
+
+		o, ok := topics.Groups().Offset("workers", "Test", 0)
+		if !ok || o.Offset != tc.expected {
+			t.Error("Expected the reset to be committed: ", o)
+			return
+		}
+	}
+
+	_, err = topics.Groups().Reset("workers", "Test", nil, WalOffsetReset{To: "yesterday"})
+	if we, ok := err.(WalError); !ok || we.Code() != ErrInvalidGroupRequest {
+		t.Error("Expected an invalid reset target but found: ", err)
+	}
+}
+
+func TestConsumerGroupCommitsDoNotBlockMembers(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	topics, err := NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer topics.Close()
+
+	_, err = topics.CreateTopic(WalTopicConfig{Name: "Test", PartitionCount: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := topics.Groups()
+	a, err := groups.Join("workers", "m1", []string{"Test"}, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Holding the partition writer of the offsets topic keeps the commit writing.
+	pw := groups.writer.partition(0).partitionWriter
+	pw.mutex.Lock()
+
+	commits := []WalOffsetCommit{{Partition: 0, Offset: 1}, {Partition: 1, Offset: 2}, {Partition: 2, Offset: 3}, {Partition: 3, Offset: 4}}
+	done := make(chan error, 1)
+	go func() {
+		_, err := groups.CommitOffsets("workers", a.WalGroupGeneration, "Test", commits)
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	_, err = groups.Heartbeat("workers", "m1")
+	elapsed := time.Since(start)
+	pw.mutex.Unlock()
+	if err != nil || elapsed > 100*time.Millisecond {
+		t.Error("Expected the heartbeat not to wait for the commit: ", elapsed, " ", err)
+	}
+
+	err = <-done
+	if err != nil {
+		t.Error("Failed to commit offsets: ", err)
+		return
+	}
+
+	offsets := groups.Offsets("workers", "Test")
+	if len(offsets) != 4 || offsets[3].Offset != 4 {
+		t.Error("Unexpected offsets: ", offsets)
+	}
+}
+
+func TestConsumerGroupOffsetsRemovedWithTopic(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	topics, err := NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"Test", "Kept"} {
+		_, err = topics.CreateTopic(WalTopicConfig{Name: name, PartitionCount: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = topics.Groups().Commit("workers", WalGroupGeneration{}, name, 1, 12, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = topics.DeleteTopic("Test")
+	if err != nil {
+		t.Error("Failed to delete topic: ", err)
+		topics.Close()
+		return
+	}
+
+	_, err = topics.CreateTopic(WalTopicConfig{Name: "Test", PartitionCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topics.Close()
+
+	topics, err = NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer topics.Close()
+
+	if offsets := topics.Groups().Offsets("workers", ""); len(offsets) != 1 || offsets[0].Topic != "Kept" {
+		t.Error("Expected only the offsets of the kept topic but found: ", offsets)
+	}
+}
+
+func TestConsumerGroupCommitsCheckedAgainAfterWrite(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	topics, err := NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"Test", "Gone"} {
+		_, err = topics.CreateTopic(WalTopicConfig{Name: name, PartitionCount: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = topics.Groups().Commit("workers", WalGroupGeneration{}, name, 0, 5, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//A member joins while the reset is written.
+	groups := topics.Groups()
+	groups.written = func() {
+		groups.written = func() {}
+		_, err := groups.Join("workers", "m1", []string{"Test"}, "", 0)
+		if err != nil {
+			t.Error("Failed to join: ", err)
+		}
+	}
+
+	_, err = groups.Reset("workers", "Test", nil, WalOffsetReset{To: ResetToOffset, Offset: 1})
+	if we, ok := err.(WalError); !ok || we.Code() != ErrGroupNotEmpty {
+		t.Error("Expected the reset to fail as the group is not empty but found: ", err)
+	}
+
+	//The partition moves to a second member while the first one commits.
+	a, err := groups.Join("workers", "m1", []string{"Test"}, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups.written = func() {
+		groups.written = func() {}
+		_, err := groups.Join("workers", "m2", []string{"Test"}, "", 0)
+		if err != nil {
+			t.Error("Failed to join: ", err)
+		}
+	}
+
+	_, err = groups.Commit("workers", a.WalGroupGeneration, "Test", 0, 9, "")
+	if we, ok := err.(WalError); !ok || we.Code() != ErrIllegalGeneration {
+		t.Error("Expected the zombie commit to be fenced out but found: ", err)
+	}
+
+	for _, m := range []string{"m1", "m2"} {
+		err = groups.Leave("workers", m)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//The topic gets deleted while its offset is written, the delete waits for the commit.
+	deleted := make(chan error, 1)
+	groups.written = func() {
+		groups.written = func() {}
+		go func() {
+			deleted <- topics.DeleteTopic("Gone")
+		}()
+
+		for topics.Topic("Gone") != nil {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	_, err = groups.Commit("workers", WalGroupGeneration{}, "Gone", 0, 7, "")
+	if we, ok := err.(WalError); !ok || we.Code() != ErrTopicNotFound {
+		t.Error("Expected the commit for the deleted topic to fail but found: ", err)
+	}
+
+	err = <-deleted
+	if err != nil {
+		t.Error("Failed to delete topic: ", err)
+	}
+
+	_, err = topics.CreateTopic(WalTopicConfig{Name: "Gone", PartitionCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for restarted := false; ; restarted = true {
+		offsets := topics.Groups().Offsets("workers", "")
+		if len(offsets) != 1 || offsets[0].Topic != "Test" || offsets[0].Offset != 5 {
+			t.Error("Unexpected offsets, restarted ", restarted, ": ", offsets)
+		}
+
+		topics.Close()
+		if restarted {
+			return
+		}
+
+		topics, err = NewWalTopicManager(dir, testTopicDefaults())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
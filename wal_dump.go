@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//dumpRecord a record as printed by the dump command.
+type dumpRecord struct {
+	Topic     string `json:"topic,omitempty"`
+	Partition uint32 `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Timestamp int64  `json:"timestamp"`
+	Time      string `json:"time"`
+	Sequence  uint64 `json:"sequence"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	CrcOk     bool   `json:"crcOk"`
+}
+
+//dumpFilter selects the records printed by the dump command. An empty key matches every key.
+type dumpFilter struct {
+	key        string
+	since      int64
+	until      int64
+	fromOffset int64
+	toOffset   int64
+}
+
+func (f *dumpFilter) match(e *WalPartitionEntry) bool {
+	if f.key != "" && e.Record.Record.Key != f.key {
+		return false
+	}
+
+	ts := e.Record.ID.Timestamp
+	return ts >= f.since && ts <= f.until && e.Offset >= f.fromOffset
+}
+
+//dumpPrinter writes records either as json lines or as a table.
+type dumpPrinter struct {
+	encoding string
+	json     *json.Encoder
+	table    *tabwriter.Writer
+}
+
+func newDumpPrinter(out io.Writer, format string, encoding string) (*dumpPrinter, error) {
+	switch encoding {
+	case "utf8", "hex", "base64":
+	default:
+		return nil, fmt.Errorf("Unknown value encoding: %s", encoding)
+	}
+
+	ret := &dumpPrinter{
+		encoding: encoding,
+	}
+
+	switch format {
+	case "json":
+		ret.json = json.NewEncoder(out)
+	case "table":
+		ret.table = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(ret.table, "TOPIC\tPARTITION\tOFFSET\tTIME\tSEQUENCE\tKEY\tVALUE\tCRC")
+	default:
+		return nil, fmt.Errorf("Unknown format: %s", format)
+	}
+
+	return ret, nil
+}
+
+func (p *dumpPrinter) print(topic string, partition uint32, e *WalPartitionEntry) error {
+	r := &dumpRecord{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    e.Offset,
+		Timestamp: e.Record.ID.Timestamp,
+		Time:      time.Unix(0, e.Record.ID.Timestamp).UTC().Format(time.RFC3339Nano),
+		Sequence:  e.Record.ID.Sequence,
+		Key:       e.Record.Record.Key,
+		CrcOk:     e.CrcOk,
+	}
+
+	switch p.encoding {
+	case "hex":
+		r.Value = hex.EncodeToString(e.Record.Record.Value)
+	case "base64":
+		r.Value = base64.StdEncoding.EncodeToString(e.Record.Record.Value)
+	default:
+		r.Value = string(e.Record.Record.Value)
+	}
+
+	if p.json != nil {
+		return p.json.Encode(r)
+	}
+
+	crc := "ok"
+	if !r.CrcOk {
+		crc = "bad"
+	}
+
+	_, err := fmt.Fprintf(p.table, "%s\t%d\t%d\t%s\t%d\t%q\t%q\t%s\n", r.Topic, r.Partition, r.Offset, r.Time, r.Sequence, r.Key, r.Value, crc)
+	return err
+}
+
+func (p *dumpPrinter) flush() error {
+	if p.table != nil {
+		return p.table.Flush()
+	}
+
+	return nil
+}
+
+//dumpSegment prints the records of a single segment file. Offsets start at the base offset found in its index, 0 without one.
+func dumpSegment(segmentPath string, maxEntrySize int64, filter *dumpFilter, printer *dumpPrinter) error {
+	_, err := os.Stat(segmentPath)
+	if err != nil {
+		return err
+	}
+
+	idx, err := ReadSegmentIndex(segmentPath)
+	if os.IsNotExist(err) {
+		idx = &WalSegmentIndex{}
+	} else if err != nil {
+		return err
+	}
+
+	partition, _ := strconv.ParseUint(filepath.Base(filepath.Dir(segmentPath)), 10, 32)
+	topic := filepath.Base(filepath.Dir(filepath.Dir(segmentPath)))
+
+	return dumpSegmentEntries(segmentPath, idx, idx.Lookup(filter.fromOffset), maxEntrySize, topic, uint32(partition), filter, printer)
+}
+
+//dumpSegmentEntries prints the records of the segment selected by the filter, scanning from the start entry.
+func dumpSegmentEntries(segmentPath string, idx *WalSegmentIndex, start WalIndexEntry, maxEntrySize int64, topic string, partition uint32, filter *dumpFilter, printer *dumpPrinter) error {
+	var printErr error
+	_, err := ScanSegment(segmentPath, idx, start, maxEntrySize, func(offset int64, position int64, wr *WalExRecord, crcOk bool) {
+		e := &WalPartitionEntry{Offset: offset, Record: wr, CrcOk: crcOk}
+		if printErr != nil || offset >= filter.toOffset || !filter.match(e) {
+			return
+		}
+
+		printErr = printer.print(topic, partition, e)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return printErr
+}
+
+//dumpPartition prints the records of a partition selected by the filter.
+// The segments are scanned directly and missing indexes are rebuilt in memory only, the dump never writes to the data it inspects.
+func dumpPartition(partitionDir Path, maxEntrySize int64, topic string, partition uint32, filter *dumpFilter, printer *dumpPrinter) error {
+	segments, err := loadPartitionSegments(partitionDir, maxEntrySize, false)
+	if err != nil {
+		return err
+	}
+
+	for i, s := range segments {
+		if s.Index.BaseOffset >= filter.toOffset {
+			return nil
+		}
+
+		if i+1 < len(segments) && segments[i+1].Index.BaseOffset <= filter.fromOffset {
+			continue
+		}
+
+		err = dumpSegmentEntries(s.Path, s.Index, s.Index.Lookup(filter.fromOffset), maxEntrySize, topic, partition, filter, printer)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//runDump implements the dump subcommand. Returns 0 on success, 2 on errors.
+func runDump(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	flags.SetOutput(out)
+	dir := flags.String("dir", "data", "Data directory holding the topics.")
+	topic := flags.String("topic", "", "Topic to dump.")
+	partition := flags.Int("partition", -1, "Partition to dump, all partitions when negative.")
+	segment := flags.String("segment", "", "Single segment file to dump, overrides dir, topic and partition.")
+	format := flags.String("format", "json", "Output format: json or table.")
+	encoding := flags.String("value", "utf8", "Value encoding: utf8, hex or base64.")
+	key := flags.String("key", "", "Only records with this key.")
+	since := flags.String("since", "", "Only records at or after this time, RFC3339 or unix nanoseconds.")
+	until := flags.String("until", "", "Only records at or before this time, RFC3339 or unix nanoseconds.")
+	fromOffset := flags.Int64("from-offset", 0, "First offset to print.")
+	toOffset := flags.Int64("to-offset", -1, "Offset to stop at, exclusive. No limit when negative.")
+	maxEntrySize := flags.Int64("max-entry-size", 0, "Entries above this size are garbage, defaults to the topic max record size.")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	log.SetLevel(log.WarnLevel)
+	filter := &dumpFilter{
+		key:        *key,
+		since:      math.MinInt64,
+		until:      math.MaxInt64,
+		fromOffset: *fromOffset,
+		toOffset:   *toOffset,
+	}
+
+	if filter.toOffset < 0 {
+		filter.toOffset = math.MaxInt64
+	}
+
+	if *since != "" {
+		filter.since, err = ParseTimestamp(*since)
+		if err != nil {
+			fmt.Fprintln(out, "Invalid since: ", err)
+			return 2
+		}
+	}
+
+	if *until != "" {
+		filter.until, err = ParseTimestamp(*until)
+		if err != nil {
+			fmt.Fprintln(out, "Invalid until: ", err)
+			return 2
+		}
+	}
+
+	printer, err := newDumpPrinter(out, *format, *encoding)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+
+	if *segment != "" {
+		err = dumpSegment(*segment, *maxEntrySize, filter, printer)
+	} else {
+		err = dumpTopic(Path(*dir), *topic, *partition, *maxEntrySize, filter, printer)
+	}
+
+	if err == nil {
+		err = printer.flush()
+	}
+
+	if err != nil {
+		fmt.Fprintln(out, "Dump failed: ", err)
+		return 2
+	}
+
+	return 0
+}
+
+func dumpTopic(dir Path, topic string, partition int, maxEntrySize int64, filter *dumpFilter, printer *dumpPrinter) error {
+	configs, err := ReadTopicsManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, tc := range configs {
+		if tc.Name != topic {
+			continue
+		}
+
+		if partition >= int(tc.PartitionCount) {
+			return fmt.Errorf("No such partition: %d", partition)
+		}
+
+		if maxEntrySize == 0 && tc.MaxRecordSize != nil {
+			maxEntrySize = *tc.MaxRecordSize + WalExRecordOverhead
+		}
+
+		var p uint32
+		for p = 0; p < tc.PartitionCount; p++ {
+			if partition >= 0 && p != uint32(partition) {
+				continue
+			}
+
+			err := dumpPartition(dir.Add(tc.Name).AddUint32(p), maxEntrySize, tc.Name, p, filter, printer)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("No such topic: %s", topic)
+}
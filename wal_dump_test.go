@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDumpFiltersRecords(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	topics, err := NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Fatal("Failed to create topic manager: ", err)
+	}
+
+	twr, err := topics.CreateTopic(WalTopicConfig{Name: "Test", PartitionCount: 1})
+	if err != nil {
+		t.Fatal("Failed to create topic: ", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		err := <-twr.WriteWalRecord(&WalRecord{Key: fmt.Sprint("k", i%2), Value: []byte(fmt.Sprint("v", i))})
+		if err != nil {
+			t.Fatal("Failed to write record: ", err)
+		}
+	}
+
+	topics.Close()
+
+	out := &bytes.Buffer{}
+	code := runDump([]string{"-dir", dir.String(), "-topic", "Test", "-key", "k1", "-from-offset", "2", "-to-offset", "8", "-value", "hex"}, out)
+	if code != 0 {
+		t.Error("Dump failed: ", code, " ", out.String())
+		return
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Error("Expected offsets 3, 5 and 7 but found: ", out.String())
+		return
+	}
+
+	r := &dumpRecord{}
+	err = json.Unmarshal([]byte(lines[0]), r)
+	if err != nil || r.Offset != 3 || r.Key != "k1" || r.Value != "7633" || !r.CrcOk || r.Sequence != 4 {
+		t.Error("Unexpected record: ", lines[0], " ", err)
+		return
+	}
+
+	segments, _ := ListWalSegments(dir.Add("Test").AddUint32(0).String())
+	out.Reset()
+	code = runDump([]string{"-segment", dir.Add("Test").AddUint32(0).Add(segments[0]).String(), "-format", "table"}, out)
+	if code != 0 || strings.Count(out.String(), "\n") != 11 || !strings.Contains(out.String(), `"v9"`) {
+		t.Error("Unexpected table: ", code, " ", out.String())
+	}
+}
+
+func TestDumpDoesNotWriteIndexes(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	defaults := testTopicDefaults()
+	defaults.MaxSegmentSize = 100
+	topics, err := NewWalTopicManager(dir, defaults)
+	if err != nil {
+		t.Fatal("Failed to create topic manager: ", err)
+	}
+
+	twr, err := topics.CreateTopic(WalTopicConfig{Name: "Test", PartitionCount: 1})
+	if err != nil {
+		t.Fatal("Failed to create topic: ", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		err := <-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte(fmt.Sprint("v", i))})
+		if err != nil {
+			t.Fatal("Failed to write record: ", err)
+		}
+	}
+
+	topics.Close()
+
+	partitionDir := dir.Add("Test").AddUint32(0)
+	segments, _ := ListWalSegments(partitionDir.String())
+	if len(segments) < 2 {
+		t.Error("Expected several segments but found: ", segments)
+		return
+	}
+
+	for _, s := range segments {
+		segmentPath := partitionDir.Add(s).String()
+		os.Remove(SegmentIndexPath(segmentPath))
+		os.Remove(SegmentTimeIndexPath(segmentPath))
+	}
+
+	out := &bytes.Buffer{}
+	code := runDump([]string{"-dir", dir.String(), "-topic", "Test", "-from-offset", "5"}, out)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if code != 0 || len(lines) != 5 || !strings.Contains(lines[0], `"offset":5`) {
+		t.Error("Unexpected dump: ", code, " ", out.String())
+		return
+	}
+
+	for _, s := range segments {
+		segmentPath := partitionDir.Add(s).String()
+		_, ierr := os.Stat(SegmentIndexPath(segmentPath))
+		_, terr := os.Stat(SegmentTimeIndexPath(segmentPath))
+		if !os.IsNotExist(ierr) || !os.IsNotExist(terr) {
+			t.Error("Dump wrote indexes of: ", s)
+			return
+		}
+	}
+}
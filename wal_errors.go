@@ -9,11 +9,57 @@ const (
 
 	//ErrSegmentSizeLimitReached the wal segment size limit has been reached.
 	ErrSegmentSizeLimitReached = 2
+
+	//ErrChecksumMismatch the crc stored with the entry does not match its content.
+	ErrChecksumMismatch = 3
+
+	//ErrTopicExists a topic with the same name is already registered.
+	ErrTopicExists = 4
+
+	//ErrTopicNotFound no topic registered under the name.
+	ErrTopicNotFound = 5
+
+	//ErrInvalidTopicConfig the topic config has missing or nonsense values.
+	ErrInvalidTopicConfig = 6
+
+	//ErrRecordSizeLimitReached the record is larger than the max record size of the topic.
+	ErrRecordSizeLimitReached = 7
+
+	//ErrCorruptedIndex the segment index file can not be decoded.
+	ErrCorruptedIndex = 8
+
+	//ErrOffsetOutOfRange the offset is before the log start offset of the partition, retention deleted it.
+	ErrOffsetOutOfRange = 9
+
+	//ErrInvalidGroupRequest the consumer group request names an invalid group, partition, offset or reset target.
+	ErrInvalidGroupRequest = 10
+
+	//ErrUnknownMember the member is not part of the consumer group, it left or missed its heartbeats and has to rejoin.
+	ErrUnknownMember = 11
+
+	//ErrIllegalGeneration the commit carries a generation of the group other than the current one, or a partition the
+	// member is not assigned.
+	ErrIllegalGeneration = 12
+
+	//ErrGroupNotEmpty the consumer group has members, its offsets can not be reset.
+	ErrGroupNotEmpty = 13
+
+	//ErrInvalidPartition the write targets a partition the topic does not have.
+	ErrInvalidPartition = 14
+
+	//ErrCorruptedEntry the entry read from disk is garbage, like a size prefix above the max entry size.
+	ErrCorruptedEntry = 15
 )
 
 //ErrSegLimitReached signaled when segment size limit reached.
 var ErrSegLimitReached = NewWalError(ErrSegmentSizeLimitReached, "Segment limit has been reached.")
 
+//ErrEntryTooLarge signaled when an entry size prefix is above the max entry size, which means it is garbage.
+var ErrEntryTooLarge = NewWalError(ErrCorruptedEntry, "Entry size is above the max entry size.")
+
+//ErrWrongChecksum signaled when an entry fails the crc check.
+var ErrWrongChecksum = NewWalError(ErrChecksumMismatch, "Wrong Checksum")
+
 //WalError errors encapsulation.
 type WalError struct {
 	code    ErrCode
@@ -7,11 +7,14 @@ import (
 
 //WalRecordID a complex identification for wal entries.
 type WalRecordID struct {
-	Timestamp int64
-	Sequence  uint32
-	Partition int32
+	Timestamp int64  `json:"timestamp"`
+	Sequence  uint64 `json:"sequence"`
+	Partition int32  `json:"partition"`
 }
 
+//WalExRecordOverhead bytes an entry takes on top of its key and value: timestamp, sequence, key and value lengths and crc.
+const WalExRecordOverhead = 8 + 8 + 4 + 4 + 4
+
 //WalExRecord extended wal record, includes the id and the crc.
 type WalExRecord struct {
 	Record *WalRecord
@@ -20,7 +23,7 @@ type WalExRecord struct {
 }
 
 //NewWalExRecord creates a new extended wal record from key and value.
-func NewWalExRecord(wr *WalRecord, sequence uint32, timestamp int64) *WalExRecord {
+func NewWalExRecord(wr *WalRecord, sequence uint64, timestamp int64) *WalExRecord {
 
 	ret := &WalExRecord{
 		Record: wr,
@@ -30,17 +33,23 @@ func NewWalExRecord(wr *WalRecord, sequence uint32, timestamp int64) *WalExRecor
 		},
 	}
 
-	b, err := ret.Bytes()
+	err := ret.UpdateCrc()
 	if err != nil {
 		panic(err)
 	}
 
-	ret.Crc, err = Crc32(b[:(len(b) - binary.Size(ret.Crc))])
+	return ret
+}
+
+//UpdateCrc recomputes the crc, needed after changing the id or the record.
+func (wr *WalExRecord) UpdateCrc() error {
+	b, err := wr.Bytes()
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	return ret
+	wr.Crc, err = Crc32(b[:(len(b) - binary.Size(wr.Crc))])
+	return err
 }
 
 //Bytes returns the byte representation of this structure. Partition is not included.
@@ -51,8 +60,7 @@ func (wr *WalExRecord) Bytes() ([]byte, error) {
 	binary.LittleEndian.PutUint64(tmpBuff, uint64(wr.ID.Timestamp))
 	buff.Write(tmpBuff)
 
-	tmpBuff = tmpBuff[:4]
-	binary.LittleEndian.PutUint32(tmpBuff, uint32(wr.ID.Sequence))
+	binary.LittleEndian.PutUint64(tmpBuff, wr.ID.Sequence)
 	buff.Write(tmpBuff)
 
 	recBuff, err := wr.Record.Bytes()
@@ -85,7 +93,7 @@ func (wr *WalExRecord) Write(p []byte) (n int, err error) {
 		return -1, NewWalError(ErrSliceNotLargeEnough, "Slice length not large enough. Could not read sequence.")
 	}
 
-	wr.ID.Sequence = uint32(binary.LittleEndian.Uint32(p[idx:]))
+	wr.ID.Sequence = binary.LittleEndian.Uint64(p[idx:])
 	idx += uint32(binary.Size(wr.ID.Sequence))
 
 	cnt, err := wr.Record.Write(p[idx:])
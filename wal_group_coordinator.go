@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//Partition assignment strategies.
+const (
+	RangeAssignment      = "range"
+	RoundRobinAssignment = "roundrobin"
+)
+
+const (
+	//DefaultSessionTimeout how long a member may go without a heartbeat before it gets fenced out.
+	DefaultSessionTimeout = 10 * time.Second
+
+	maxSessionTimeout = 5 * time.Minute
+)
+
+//WalGroupGeneration identifies the member committing on behalf of a group. The zero value commits outside of any
+// generation, which only groups without members accept.
+type WalGroupGeneration struct {
+	MemberID   string `json:"memberId"`
+	Generation int64  `json:"generation"`
+}
+
+//WalGroupAssignment the partitions a member reads in the current generation of its group, by topic.
+type WalGroupAssignment struct {
+	WalGroupGeneration
+	Assignment map[string][]uint32 `json:"assignment"`
+}
+
+//WalGroupMember a member of a consumer group as described by the coordinator.
+type WalGroupMember struct {
+	ID             string              `json:"memberId"`
+	Topics         []string            `json:"topics"`
+	SessionTimeout time.Duration       `json:"sessionTimeout"`
+	LastHeartbeat  time.Time           `json:"lastHeartbeat"`
+	Assignment     map[string][]uint32 `json:"assignment"`
+}
+
+//WalGroupDescription the members of a consumer group along with its generation.
+type WalGroupDescription struct {
+	Group      string            `json:"group"`
+	Generation int64             `json:"generation"`
+	Strategy   string            `json:"strategy,omitempty"`
+	Members    []*WalGroupMember `json:"members"`
+}
+
+//walGroupState the coordinator state of a group, only kept in memory. Members rejoin after a restart.
+type walGroupState struct {
+	generation int64
+	strategy   string
+	members    map[string]*WalGroupMember
+}
+
+//Join adds a member to the group and rebalances the partitions of the topics its members read. A member rejoining with
+// its id and the same topics keeps its assignment. An empty strategy takes the one of the group, range by default.
+func (g *WalConsumerGroups) Join(group string, memberID string, topics []string, strategy string, sessionTimeout time.Duration) (*WalGroupAssignment, error) {
+	if !topicNamePattern.MatchString(group) {
+		return nil, NewWalError(ErrInvalidGroupRequest, fmt.Sprint("Invalid group name: ", group))
+	}
+
+	if len(topics) == 0 {
+		return nil, NewWalError(ErrInvalidGroupRequest, "Members must read at least one topic.")
+	}
+
+	for _, topic := range topics {
+		_, err := g.validate(group, topic)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if sessionTimeout == 0 {
+		sessionTimeout = DefaultSessionTimeout
+	} else if sessionTimeout < 0 || sessionTimeout > maxSessionTimeout {
+		return nil, NewWalError(ErrInvalidGroupRequest, fmt.Sprint("Invalid session timeout: ", sessionTimeout))
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	state := g.groupState(group)
+	g.states[group] = state
+	if strategy == "" {
+		strategy = state.strategy
+	}
+
+	if strategy == "" {
+		strategy = RangeAssignment
+	} else if strategy != RangeAssignment && strategy != RoundRobinAssignment {
+		return nil, NewWalError(ErrInvalidGroupRequest, fmt.Sprint("Unknown assignment strategy: ", strategy))
+	}
+
+	if len(state.members) > 0 && strategy != state.strategy {
+		return nil, NewWalError(ErrInvalidGroupRequest, fmt.Sprint("Group ", group, " assigns with: ", state.strategy))
+	}
+
+	sorted := append([]string{}, topics...)
+	sort.Strings(sorted)
+
+	now := g.now()
+	if m, ok := state.members[memberID]; ok && equalStrings(m.Topics, sorted) {
+		m.LastHeartbeat = now
+		m.SessionTimeout = sessionTimeout
+		return state.assignment(m), nil
+	}
+
+	if memberID == "" {
+		g.memberCount++
+		memberID = fmt.Sprint(group, "-", now.UnixNano(), "-", g.memberCount)
+	}
+
+	m := &WalGroupMember{
+		ID:             memberID,
+		Topics:         sorted,
+		SessionTimeout: sessionTimeout,
+		LastHeartbeat:  now,
+	}
+
+	state.members[memberID] = m
+	state.strategy = strategy
+	log.Info("Member joined group: ", group, " member: ", memberID, " topics: ", sorted)
+
+	g.rebalance(group, state)
+	return state.assignment(m), nil
+}
+
+//Heartbeat keeps the member in the group and returns the assignment of the current generation, which changes whenever
+// members join, leave or get fenced out.
+func (g *WalConsumerGroups) Heartbeat(group string, memberID string) (*WalGroupAssignment, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	state := g.groupState(group)
+	m, ok := state.members[memberID]
+	if !ok {
+		return nil, NewWalError(ErrUnknownMember, fmt.Sprint("Unknown member: ", memberID, " of group: ", group))
+	}
+
+	m.LastHeartbeat = g.now()
+	return state.assignment(m), nil
+}
+
+//Leave removes the member from the group and rebalances the partitions among the remaining members.
+func (g *WalConsumerGroups) Leave(group string, memberID string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	state := g.groupState(group)
+	if _, ok := state.members[memberID]; !ok {
+		return NewWalError(ErrUnknownMember, fmt.Sprint("Unknown member: ", memberID, " of group: ", group))
+	}
+
+	delete(state.members, memberID)
+	log.Info("Member left group: ", group, " member: ", memberID)
+
+	g.rebalance(group, state)
+	return nil
+}
+
+//Describe returns the members of the group and their assignments.
+func (g *WalConsumerGroups) Describe(group string) *WalGroupDescription {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	state := g.groupState(group)
+	ret := &WalGroupDescription{
+		Group:      group,
+		Generation: state.generation,
+		Strategy:   state.strategy,
+		Members:    make([]*WalGroupMember, 0, len(state.members)),
+	}
+
+	for _, m := range state.members {
+		c := *m
+		ret.Members = append(ret.Members, &c)
+	}
+
+	sort.Slice(ret.Members, func(i1, i2 int) bool {
+		return ret.Members[i1].ID < ret.Members[i2].ID
+	})
+
+	return ret
+}
+
+//checkGeneration rejects commits from members fenced out or assigned to another generation, along with commits for
+// partitions the member does not own. Expects the lock to be held.
+func (g *WalConsumerGroups) checkGeneration(group string, gen WalGroupGeneration, topic string, partition uint32) error {
+	state := g.groupState(group)
+	if len(state.members) == 0 && gen.MemberID == "" {
+		return nil
+	}
+
+	m, ok := state.members[gen.MemberID]
+	if !ok {
+		return NewWalError(ErrUnknownMember, fmt.Sprint("Unknown member: ", gen.MemberID, " of group: ", group))
+	}
+
+	if gen.Generation != state.generation {
+		return NewWalError(ErrIllegalGeneration, fmt.Sprint("Generation ", gen.Generation, " of group: ", group, " is not the current one: ", state.generation))
+	}
+
+	for _, p := range m.Assignment[topic] {
+		if p == partition {
+			return nil
+		}
+	}
+
+	return NewWalError(ErrIllegalGeneration, fmt.Sprint("Partition ", partition, " of topic: ", topic, " is not assigned to member: ", gen.MemberID))
+}
+
+//groupState returns the state of the group after fencing out the members that missed their heartbeats. Groups that
+// never had members get an empty state which is not kept. Expects the lock to be held.
+func (g *WalConsumerGroups) groupState(group string) *walGroupState {
+	state, ok := g.states[group]
+	if !ok {
+		return &walGroupState{
+			members: make(map[string]*WalGroupMember),
+		}
+	}
+
+	now := g.now()
+	expired := false
+	for id, m := range state.members {
+		if now.Sub(m.LastHeartbeat) > m.SessionTimeout {
+			log.Warn("Fencing out member: ", id, " of group: ", group, " last heartbeat: ", m.LastHeartbeat)
+			delete(state.members, id)
+			expired = true
+		}
+	}
+
+	if expired {
+		g.rebalance(group, state)
+	}
+
+	return state
+}
+
+//topicResized rebalances the groups reading the topic so that its new partitions get assigned.
+func (g *WalConsumerGroups) topicResized(topic string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for group := range g.states {
+		state := g.groupState(group)
+		for _, m := range state.members {
+			if containsString(m.Topics, topic) {
+				g.rebalance(group, state)
+				break
+			}
+		}
+	}
+}
+
+//rebalance starts a new generation and assigns the partitions of every topic read by the group to its members.
+func (g *WalConsumerGroups) rebalance(group string, state *walGroupState) {
+	state.generation++
+
+	ids := make([]string, 0, len(state.members))
+	topicSet := make(map[string]bool)
+	for id, m := range state.members {
+		ids = append(ids, id)
+		m.Assignment = make(map[string][]uint32)
+		for _, t := range m.Topics {
+			topicSet[t] = true
+		}
+	}
+
+	sort.Strings(ids)
+	topics := make([]string, 0, len(topicSet))
+	for t := range topicSet {
+		topics = append(topics, t)
+	}
+
+	sort.Strings(topics)
+
+	next := 0
+	for _, topic := range topics {
+		twr := g.topics.Topic(topic)
+		if twr == nil {
+			continue
+		}
+
+		readers := make([]*WalGroupMember, 0, len(ids))
+		for _, id := range ids {
+			if containsString(state.members[id].Topics, topic) {
+				readers = append(readers, state.members[id])
+			}
+		}
+
+		count := twr.PartitionCount()
+		var p uint32
+		for p = 0; p < count; p++ {
+			var m *WalGroupMember
+			if state.strategy == RoundRobinAssignment {
+				//Continue over all members where the previous topic stopped, skipping those not reading this one.
+				for m == nil || !containsString(m.Topics, topic) {
+					m = state.members[ids[next%len(ids)]]
+					next++
+				}
+			} else {
+				m = readers[rangeAssignee(p, count, len(readers))]
+			}
+
+			m.Assignment[topic] = append(m.Assignment[topic], p)
+		}
+	}
+
+	log.Info("Rebalanced group: ", group, " generation: ", state.generation, " members: ", len(ids))
+}
+
+//rangeAssignee returns which of count readers gets partition p when every reader gets a contiguous range of partitions,
+// the first ones one more when they do not divide evenly.
+func rangeAssignee(p uint32, partitions uint32, count int) int {
+	size := int(partitions) / count
+	extra := int(partitions) % count
+
+	if int(p) < extra*(size+1) {
+		return int(p) / (size + 1)
+	}
+
+	return extra + (int(p)-extra*(size+1))/size
+}
+
+func (s *walGroupState) assignment(m *WalGroupMember) *WalGroupAssignment {
+	ret := &WalGroupAssignment{
+		WalGroupGeneration: WalGroupGeneration{
+			MemberID:   m.ID,
+			Generation: s.generation,
+		},
+		Assignment: make(map[string][]uint32, len(m.Assignment)),
+	}
+
+	for t, partitions := range m.Assignment {
+		ret.Assignment[t] = append([]uint32{}, partitions...)
+	}
+
+	return ret
+}
+
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testGroups(t *testing.T, partitions ...uint32) (*WalTopicManager, Path) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+
+	topics, err := NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, count := range partitions {
+		_, err = topics.CreateTopic(WalTopicConfig{Name: string(rune('A' + i)), PartitionCount: count})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return topics, dir
+}
+
+func TestGroupAssignmentStrategies(t *testing.T) {
+	topics, dir := testGroups(t, 5, 2)
+	defer os.RemoveAll(dir.String())
+	defer topics.Close()
+
+	groups := topics.Groups()
+	testCases := []struct {
+		strategy string
+		expected map[string]map[string][]uint32
+	}{
+		{RangeAssignment, map[string]map[string][]uint32{
+			"m1": {"A": {0, 1}, "B": {0}},
+			"m2": {"A": {2, 3}, "B": {1}},
+			"m3": {"A": {4}},
+		}},
+		{RoundRobinAssignment, map[string]map[string][]uint32{
+			"m1": {"A": {0, 3}, "B": {1}},
+			"m2": {"A": {1, 4}},
+			"m3": {"A": {2}, "B": {0}},
+		}},
+	}
+
+	for _, tc := range testCases {
+		group := "group-" + tc.strategy
+		var last *WalGroupAssignment
+		for _, id := range []string{"m1", "m2", "m3"} {
+			subscription := []string{"A", "B"}
+			if id == "m2" && tc.strategy == RoundRobinAssignment {
+				subscription = []string{"A"}
+			}
+
+			if id == "m3" && tc.strategy == RangeAssignment {
+				subscription = []string{"A"}
+			}
+
+			a, err := groups.Join(group, id, subscription, tc.strategy, 0)
+			if err != nil {
+				t.Error("Failed to join: ", err)
+				return
+			}
+
+			last = a
+		}
+
+		if last.Generation != 3 {
+			t.Error("Expected a generation per join but found: ", last.Generation)
+		}
+
+		for _, m := range groups.Describe(group).Members {
+			if !reflect.DeepEqual(m.Assignment, tc.expected[m.ID]) {
+				t.Error(tc.strategy, " unexpected assignment of: ", m.ID, " ", m.Assignment)
+			}
+		}
+
+		_, err := groups.Join(group, "m4", []string{"A"}, "sticky", 0)
+		if err == nil {
+			t.Error("Expected an unknown strategy to fail")
+		}
+	}
+}
+
+func TestGroupFencesZombieMembers(t *testing.T) {
+	topics, dir := testGroups(t, 2)
+	defer os.RemoveAll(dir.String())
+	defer topics.Close()
+
+	now := time.Now()
+	groups := topics.Groups()
+	groups.now = func() time.Time { return now }
+
+	first, err := groups.Join("workers", "", []string{"A"}, "", time.Second)
+	if err != nil || first.MemberID == "" || len(first.Assignment["A"]) != 2 {
+		t.Error("Unexpected assignment of the first member: ", first, " ", err)
+		return
+	}
+
+	_, err = groups.Commit("workers", first.WalGroupGeneration, "A", 1, 5, "")
+	if err != nil {
+		t.Error("Failed to commit with the current generation: ", err)
+		return
+	}
+
+	_, err = groups.Commit("workers", WalGroupGeneration{}, "A", 1, 5, "")
+	if errCode(err) != ErrUnknownMember {
+		t.Error("Expected commits outside of the generation to be rejected: ", err)
+	}
+
+	_, err = groups.Reset("workers", "A", nil, WalOffsetReset{To: ResetToEarliest})
+	if errCode(err) != ErrGroupNotEmpty {
+		t.Error("Expected reset of a group with members to be rejected: ", err)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	second, err := groups.Join("workers", "", []string{"A"}, "", 5*time.Second)
+	if err != nil || second.Generation != first.Generation+1 {
+		t.Error("Unexpected assignment of the second member: ", second, " ", err)
+		return
+	}
+
+	_, err = groups.Commit("workers", first.WalGroupGeneration, "A", 0, 6, "")
+	if errCode(err) != ErrIllegalGeneration {
+		t.Error("Expected a commit from the previous generation to be rejected: ", err)
+	}
+
+	now = now.Add(time.Second)
+	_, err = groups.Heartbeat("workers", first.MemberID)
+	if errCode(err) != ErrUnknownMember {
+		t.Error("Expected the member missing heartbeats to be fenced out: ", err)
+	}
+
+	current, err := groups.Heartbeat("workers", second.MemberID)
+	if err != nil || current.Generation != second.Generation+1 || len(current.Assignment["A"]) != 2 {
+		t.Error("Expected the remaining member to take over every partition: ", current, " ", err)
+		return
+	}
+
+	_, err = groups.Commit("workers", second.WalGroupGeneration, "A", 0, 6, "")
+	if errCode(err) != ErrIllegalGeneration {
+		t.Error("Expected a commit with a stale generation to be rejected: ", err)
+	}
+
+	_, err = groups.Commit("workers", current.WalGroupGeneration, "A", 0, 6, "")
+	if err != nil {
+		t.Error("Failed to commit with the new generation: ", err)
+		return
+	}
+
+	err = groups.Leave("workers", second.MemberID)
+	if err != nil {
+		t.Error("Failed to leave: ", err)
+		return
+	}
+
+	_, err = groups.Commit("workers", current.WalGroupGeneration, "A", 0, 7, "")
+	if errCode(err) != ErrUnknownMember {
+		t.Error("Expected commits of a member that left to be rejected: ", err)
+	}
+
+	_, err = groups.Reset("workers", "A", nil, WalOffsetReset{To: ResetToEarliest})
+	if err != nil {
+		t.Error("Failed to reset an empty group: ", err)
+	}
+}
+
+func errCode(err error) ErrCode {
+	if we, ok := err.(WalError); ok {
+		return we.Code()
+	}
+
+	return 0
+}
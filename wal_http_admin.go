@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type partitionDescription struct {
+	Partition   uint32             `json:"partition"`
+	StartOffset int64              `json:"startOffset"`
+	EndOffset   int64              `json:"endOffset"`
+	Recovery    *WalRecoveryReport `json:"recovery,omitempty"`
+}
+
+type partitionIncrease struct {
+	PartitionCount uint32 `json:"partitionCount"`
+	Partitioner    string `json:"partitioner"`
+}
+
+type topicDescription struct {
+	WalTopicConfig
+	Partitions []*partitionDescription `json:"partitions"`
+}
+
+func (s *WalHTTPServer) handleTopics(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.topics.Topics())
+
+	case http.MethodPost:
+		tc := WalTopicConfig{}
+		err := json.NewDecoder(r.Body).Decode(&tc)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		_, err = s.topics.CreateTopic(tc)
+		if err != nil {
+			writeError(w, walErrorStatus(err), err)
+			return
+		}
+
+		tc, _ = s.topics.TopicConfig(tc.Name)
+		writeJSON(w, http.StatusCreated, tc)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", r.Method))
+	}
+}
+
+func (s *WalHTTPServer) handleTopic(w http.ResponseWriter, r *http.Request, topic string) {
+	switch r.Method {
+	case http.MethodGet:
+		tc, ok := s.topics.TopicConfig(topic)
+		twr := s.Topic(topic)
+		if !ok || twr == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("No such topic: %s", topic))
+			return
+		}
+
+		desc := &topicDescription{
+			WalTopicConfig: tc,
+			Partitions:     make([]*partitionDescription, tc.PartitionCount),
+		}
+
+		reader := twr.NewReader()
+		var i uint32
+		for i = 0; i < tc.PartitionCount; i++ {
+			start, err := reader.StartOffset(i)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+
+			end, err := reader.EndOffset(i)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+
+			desc.Partitions[i] = &partitionDescription{
+				Partition:   i,
+				StartOffset: start,
+				EndOffset:   end,
+				Recovery:    twr.Recovery(i),
+			}
+		}
+
+		writeJSON(w, http.StatusOK, desc)
+
+	case http.MethodDelete:
+		err := s.topics.DeleteTopic(topic)
+		if err != nil {
+			writeError(w, walErrorStatus(err), err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", r.Method))
+	}
+}
+
+//handleTopicPartitions adds partitions to the topic.
+func (s *WalHTTPServer) handleTopicPartitions(w http.ResponseWriter, r *http.Request, topic string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", r.Method))
+		return
+	}
+
+	req := &partitionIncrease{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tc, err := s.topics.IncreasePartitions(topic, req.PartitionCount, req.Partitioner)
+	if err != nil {
+		writeError(w, walErrorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tc)
+}
+
+//walErrorStatus maps WalError codes to http status codes.
+func walErrorStatus(err error) int {
+	we, ok := err.(WalError)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+
+	switch we.Code() {
+	case ErrTopicExists, ErrIllegalGeneration, ErrGroupNotEmpty:
+		return http.StatusConflict
+	case ErrTopicNotFound, ErrUnknownMember:
+		return http.StatusNotFound
+	case ErrInvalidTopicConfig, ErrInvalidGroupRequest, ErrInvalidPartition:
+		return http.StatusBadRequest
+	case ErrRecordSizeLimitReached:
+		return http.StatusRequestEntityTooLarge
+	case ErrOffsetOutOfRange:
+		return http.StatusRequestedRangeNotSatisfiable
+	default:
+		return http.StatusInternalServerError
+	}
+}
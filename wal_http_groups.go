@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type groupOffsetCommit struct {
+	Partition uint32 `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Metadata  string `json:"metadata"`
+}
+
+type groupCommitRequest struct {
+	WalGroupGeneration
+	Offsets []*groupOffsetCommit `json:"offsets"`
+}
+
+type groupJoinRequest struct {
+	MemberID             string   `json:"memberId"`
+	Topics               []string `json:"topics"`
+	Strategy             string   `json:"strategy"`
+	SessionTimeoutMillis int64    `json:"sessionTimeoutMillis"`
+}
+
+type groupResetRequest struct {
+	To        string  `json:"to"`
+	Partition *uint32 `json:"partition"`
+	Timestamp string  `json:"timestamp"`
+	Offset    int64   `json:"offset"`
+}
+
+type groupPartitionOffset struct {
+	*WalGroupOffset
+	EndOffset int64 `json:"endOffset"`
+	Lag       int64 `json:"lag"`
+}
+
+type groupOffsetsResponse struct {
+	Offsets []*groupPartitionOffset `json:"offsets"`
+}
+
+func (s *WalHTTPServer) handleGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", r.Method))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.topics.Groups().Groups())
+}
+
+//handleGroupOffsets returns the offsets committed by the group along with the lag behind the end of each partition,
+// POST commits offsets on the topic.
+func (s *WalHTTPServer) handleGroupOffsets(w http.ResponseWriter, r *http.Request, group string, topic string) {
+	groups := s.topics.Groups()
+
+	switch r.Method {
+	case http.MethodGet:
+		s.writeGroupOffsets(w, groups.Offsets(group, topic))
+
+	case http.MethodPost:
+		if topic == "" {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", r.Method))
+			return
+		}
+
+		req := &groupCommitRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		commits := make([]WalOffsetCommit, len(req.Offsets))
+		for i, c := range req.Offsets {
+			if c == nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("Null offset at: %d", i))
+				return
+			}
+
+			commits[i] = WalOffsetCommit{Partition: c.Partition, Offset: c.Offset, Metadata: c.Metadata}
+		}
+
+		ret, err := groups.CommitOffsets(group, req.WalGroupGeneration, topic, commits)
+		if err != nil {
+			writeError(w, walErrorStatus(err), err)
+			return
+		}
+
+		s.writeGroupOffsets(w, ret)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", r.Method))
+	}
+}
+
+//handleGroupMembers describes the members of the group, POST joins the group and returns the assignment of the member.
+func (s *WalHTTPServer) handleGroupMembers(w http.ResponseWriter, r *http.Request, group string) {
+	groups := s.topics.Groups()
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, groups.Describe(group))
+
+	case http.MethodPost:
+		req := &groupJoinRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		timeout := time.Duration(req.SessionTimeoutMillis) * time.Millisecond
+		assignment, err := groups.Join(group, req.MemberID, req.Topics, req.Strategy, timeout)
+		if err != nil {
+			writeError(w, walErrorStatus(err), err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, assignment)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", r.Method))
+	}
+}
+
+//handleGroupMember removes the member from the group on DELETE.
+func (s *WalHTTPServer) handleGroupMember(w http.ResponseWriter, r *http.Request, group string, memberID string) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", r.Method))
+		return
+	}
+
+	err := s.topics.Groups().Leave(group, memberID)
+	if err != nil {
+		writeError(w, walErrorStatus(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//handleGroupHeartbeat keeps the member in the group and returns its assignment in the current generation.
+func (s *WalHTTPServer) handleGroupHeartbeat(w http.ResponseWriter, r *http.Request, group string, memberID string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", r.Method))
+		return
+	}
+
+	assignment, err := s.topics.Groups().Heartbeat(group, memberID)
+	if err != nil {
+		writeError(w, walErrorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, assignment)
+}
+
+//handleGroupReset moves the offsets of the group to the earliest or latest offset, a timestamp or an explicit offset.
+func (s *WalHTTPServer) handleGroupReset(w http.ResponseWriter, r *http.Request, group string, topic string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", r.Method))
+		return
+	}
+
+	req := &groupResetRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	reset := WalOffsetReset{
+		To:     req.To,
+		Offset: req.Offset,
+	}
+
+	if req.To == ResetToTimestamp {
+		reset.Timestamp, err = ParseTimestamp(req.Timestamp)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid timestamp: %v", err))
+			return
+		}
+	}
+
+	offsets, err := s.topics.Groups().Reset(group, topic, req.Partition, reset)
+	if err != nil {
+		writeError(w, walErrorStatus(err), err)
+		return
+	}
+
+	s.writeGroupOffsets(w, offsets)
+}
+
+func (s *WalHTTPServer) writeGroupOffsets(w http.ResponseWriter, offsets []*WalGroupOffset) {
+	resp := &groupOffsetsResponse{
+		Offsets: make([]*groupPartitionOffset, 0, len(offsets)),
+	}
+
+	for _, o := range offsets {
+		po := &groupPartitionOffset{WalGroupOffset: o}
+		if twr := s.Topic(o.Topic); twr != nil && o.Partition < twr.PartitionCount() {
+			end, err := twr.NewReader().EndOffset(o.Partition)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+
+			po.EndOffset = end
+			po.Lag = end - o.Offset
+		}
+
+		resp.Offsets = append(resp.Offsets, po)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHTTPGroupOffsets(t *testing.T) {
+	server, twr, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	for i := 0; i < 3; i++ {
+		<-twr.WriteWalRecord(&WalRecord{Key: "Hey", Value: []byte{byte(i)}})
+	}
+
+	crc, _ := Crc32([]byte("Hey"))
+	partition := crc % 2
+
+	body := fmt.Sprintf(`{"offsets":[{"partition":%d,"offset":1,"metadata":"worker-1"}]}`, partition)
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/groups/workers/offsets/Test", strings.NewReader(body)))
+	if resp.Code != http.StatusOK {
+		t.Error("Expected 200 but found: ", resp.Code, " ", resp.Body.String())
+		return
+	}
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/groups/workers/offsets/Test", nil))
+
+	gr := &groupOffsetsResponse{}
+	err := json.NewDecoder(resp.Body).Decode(gr)
+	if err != nil || len(gr.Offsets) != 1 || gr.Offsets[0].Offset != 1 || gr.Offsets[0].Lag != 2 || gr.Offsets[0].Metadata != "worker-1" {
+		t.Error("Unexpected committed offsets: ", resp.Body.String(), " ", err)
+		return
+	}
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/groups/workers/offsets/Test/reset", strings.NewReader(`{"to":"latest"}`)))
+
+	gr = &groupOffsetsResponse{}
+	err = json.NewDecoder(resp.Body).Decode(gr)
+	if resp.Code != http.StatusOK || err != nil || len(gr.Offsets) != 2 || gr.Offsets[partition].Offset != 3 || gr.Offsets[partition].Lag != 0 {
+		t.Error("Unexpected reset to latest: ", resp.Code, " ", err)
+		return
+	}
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/groups", nil))
+
+	var groups []string
+	err = json.NewDecoder(resp.Body).Decode(&groups)
+	if err != nil || len(groups) != 1 || groups[0] != "workers" {
+		t.Error("Unexpected groups: ", groups, " ", err)
+		return
+	}
+
+	for _, tc := range []struct {
+		url    string
+		body   string
+		status int
+	}{
+		{"/groups/workers/offsets/Missing", `{"offsets":[{"partition":0,"offset":1}]}`, http.StatusNotFound},
+		{"/groups/workers/offsets/Test", `{"offsets":[{"partition":9,"offset":1}]}`, http.StatusBadRequest},
+		{"/groups/workers/offsets/Test/reset", `{"to":"timestamp","timestamp":"yesterday"}`, http.StatusBadRequest},
+		{"/topics/__consumer_offsets/records", `{"key":"k","value":"CwHf"}`, http.StatusNotFound},
+	} {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, tc.url, strings.NewReader(tc.body)))
+		if resp.Code != tc.status {
+			t.Error("Expected ", tc.status, " for: ", tc.url, " but found: ", resp.Code, " ", resp.Body.String())
+		}
+	}
+}
+
+func TestHTTPGroupMembers(t *testing.T) {
+	server, _, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	join := func(body string) (*WalGroupAssignment, int) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/groups/workers/members", strings.NewReader(body)))
+
+		a := &WalGroupAssignment{}
+		json.NewDecoder(resp.Body).Decode(a)
+		return a, resp.Code
+	}
+
+	first, status := join(`{"topics":["Test"]}`)
+	if status != http.StatusOK || first.MemberID == "" || len(first.Assignment["Test"]) != 2 {
+		t.Error("Unexpected join: ", status, " ", first)
+		return
+	}
+
+	second, status := join(`{"memberId":"worker-2","topics":["Test"],"sessionTimeoutMillis":30000}`)
+	if status != http.StatusOK || second.Generation != first.Generation+1 || len(second.Assignment["Test"]) != 1 {
+		t.Error("Unexpected second join: ", status, " ", second)
+		return
+	}
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/groups/workers/members/"+first.MemberID+"/heartbeat", nil))
+
+	current := &WalGroupAssignment{}
+	err := json.NewDecoder(resp.Body).Decode(current)
+	if err != nil || current.Generation != second.Generation || len(current.Assignment["Test"]) != 1 {
+		t.Error("Expected the heartbeat to return the new assignment: ", resp.Body.String(), " ", err)
+		return
+	}
+
+	partition := current.Assignment["Test"][0]
+	for _, tc := range []struct {
+		gen    WalGroupGeneration
+		status int
+	}{
+		{first.WalGroupGeneration, http.StatusConflict},
+		{WalGroupGeneration{MemberID: "zombie", Generation: current.Generation}, http.StatusNotFound},
+		{current.WalGroupGeneration, http.StatusOK},
+	} {
+		body := fmt.Sprintf(`{"memberId":%q,"generation":%d,"offsets":[{"partition":%d,"offset":1}]}`, tc.gen.MemberID, tc.gen.Generation, partition)
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/groups/workers/offsets/Test", strings.NewReader(body)))
+		if resp.Code != tc.status {
+			t.Error("Expected ", tc.status, " for commit of: ", tc.gen, " but found: ", resp.Code, " ", resp.Body.String())
+		}
+	}
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/groups/workers/offsets/Test/reset", strings.NewReader(`{"to":"latest"}`)))
+	if resp.Code != http.StatusConflict {
+		t.Error("Expected reset of a group with members to conflict but found: ", resp.Code)
+	}
+
+	for _, id := range []string{first.MemberID, "worker-2"} {
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, "/groups/workers/members/"+id, nil))
+		if resp.Code != http.StatusNoContent {
+			t.Error("Expected 204 on leave but found: ", resp.Code, " ", resp.Body.String())
+		}
+	}
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/groups/workers/members", nil))
+
+	desc := &WalGroupDescription{}
+	err = json.NewDecoder(resp.Body).Decode(desc)
+	if err != nil || len(desc.Members) != 0 || desc.Generation != second.Generation+2 {
+		t.Error("Unexpected group after every member left: ", resp.Body.String(), " ", err)
+	}
+}
@@ -1 +1,469 @@
 package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//WalHTTPServer exposes the topics of a topic manager over http.
+type WalHTTPServer struct {
+	topics *WalTopicManager
+	server *http.Server
+}
+
+const (
+	defaultConsumeMax = 100
+	maxConsumeWait    = 60 * time.Second
+
+	//jsonBodySlack room for the field names and punctuation of a produce request.
+	jsonBodySlack = 4096
+)
+
+type produceRequest struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type produceBatchRequest struct {
+	Records []*produceRequest `json:"records"`
+}
+
+type produceResult struct {
+	*WalRecordID
+	Error string `json:"error,omitempty"`
+}
+
+type produceBatchResponse struct {
+	Results []*produceResult `json:"results"`
+}
+
+type consumedRecord struct {
+	Offset    int64  `json:"offset"`
+	Partition int32  `json:"partition"`
+	Key       string `json:"key"`
+	Value     []byte `json:"value"`
+	Timestamp int64  `json:"timestamp"`
+	Sequence  uint64 `json:"sequence"`
+	CrcOk     bool   `json:"crcOk"`
+}
+
+type consumeResponse struct {
+	Records    []*consumedRecord `json:"records"`
+	NextOffset int64             `json:"nextOffset"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+//NewWalHTTPServer creates a new http server listening on host and port.
+func NewWalHTTPServer(host string, port int, topics *WalTopicManager) *WalHTTPServer {
+	ret := &WalHTTPServer{
+		topics: topics,
+	}
+
+	ret.server = &http.Server{
+		Addr:    net.JoinHostPort(host, fmt.Sprint(port)),
+		Handler: ret,
+	}
+
+	return ret
+}
+
+//Topic returns the topic writer registered under name or nil. Internal topics are not exposed.
+func (s *WalHTTPServer) Topic(name string) *WalTopicWriter {
+	if strings.HasPrefix(name, internalTopicPrefix) {
+		return nil
+	}
+
+	return s.topics.Topic(name)
+}
+
+//ListenAndServe blocks serving requests until the server is closed.
+func (s *WalHTTPServer) ListenAndServe() error {
+	log.Info("Starting server on: ", s.server.Addr)
+	return s.server.ListenAndServe()
+}
+
+//Close closes the listener and all active connections.
+func (s *WalHTTPServer) Close() error {
+	log.Debug("Closing http server.")
+	return s.server.Close()
+}
+
+func (s *WalHTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Debug("Received request: ", r.Method, " ", r.URL.Path)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "ws":
+		s.handleWebSocket(w, r)
+	case len(parts) == 1 && parts[0] == "topics":
+		s.handleTopics(w, r)
+	case len(parts) == 2 && parts[0] == "topics":
+		s.handleTopic(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "topics" && parts[2] == "records":
+		s.handleRecords(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "topics" && parts[2] == "records:batch":
+		s.handleRecordsBatch(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "topics" && parts[2] == "partitions":
+		s.handleTopicPartitions(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "topics" && parts[2] == "stream":
+		s.handleStream(w, r, parts[1])
+	case len(parts) == 5 && parts[0] == "topics" && parts[2] == "partitions" && parts[4] == "records":
+		s.handlePartitionRecords(w, r, parts[1], parts[3])
+	case len(parts) == 1 && parts[0] == "groups":
+		s.handleGroups(w, r)
+	case len(parts) == 3 && parts[0] == "groups" && parts[2] == "members":
+		s.handleGroupMembers(w, r, parts[1])
+	case len(parts) == 4 && parts[0] == "groups" && parts[2] == "members":
+		s.handleGroupMember(w, r, parts[1], parts[3])
+	case len(parts) == 5 && parts[0] == "groups" && parts[2] == "members" && parts[4] == "heartbeat":
+		s.handleGroupHeartbeat(w, r, parts[1], parts[3])
+	case len(parts) == 3 && parts[0] == "groups" && parts[2] == "offsets":
+		s.handleGroupOffsets(w, r, parts[1], "")
+	case len(parts) == 4 && parts[0] == "groups" && parts[2] == "offsets":
+		s.handleGroupOffsets(w, r, parts[1], parts[3])
+	case len(parts) == 5 && parts[0] == "groups" && parts[2] == "offsets" && parts[4] == "reset":
+		s.handleGroupReset(w, r, parts[1], parts[3])
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("No such resource: %s", r.URL.Path))
+	}
+}
+
+func (s *WalHTTPServer) handleRecords(w http.ResponseWriter, r *http.Request, topic string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", r.Method))
+		return
+	}
+
+	twr := s.Topic(topic)
+	if twr == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No such topic: %s", topic))
+		return
+	}
+
+	opts, err := writeOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	limit := twr.maxRecordSize
+	if limit <= 0 {
+		limit = s.topics.Defaults().MaxBatchSize
+	}
+
+	req := &produceRequest{}
+	err = decodeBody(w, r, limit, req)
+	if err != nil {
+		writeError(w, bodyErrorStatus(err), err)
+		return
+	}
+
+	id, retChan := twr.WriteWalRecordWithOptions(&WalRecord{
+		Key:   req.Key,
+		Value: req.Value,
+	}, opts)
+
+	select {
+	case err = <-retChan:
+		if err != nil {
+			writeError(w, walErrorStatus(err), err)
+			return
+		}
+
+		if opts.Ack == AckNone {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, id)
+	case <-r.Context().Done():
+		log.Warn("Client went away before record was written: ", r.Context().Err())
+	}
+}
+
+func (s *WalHTTPServer) handleRecordsBatch(w http.ResponseWriter, r *http.Request, topic string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", r.Method))
+		return
+	}
+
+	twr := s.Topic(topic)
+	if twr == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No such topic: %s", topic))
+		return
+	}
+
+	opts, err := writeOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	req := &produceBatchRequest{}
+	err = decodeBody(w, r, s.topics.Defaults().MaxBatchSize, req)
+	if err != nil {
+		writeError(w, bodyErrorStatus(err), err)
+		return
+	}
+
+	if len(req.Records) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Empty batch."))
+		return
+	}
+
+	records := make([]*WalRecord, len(req.Records))
+	for i, rec := range req.Records {
+		if rec == nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Null record at: %d", i))
+			return
+		}
+
+		records[i] = &WalRecord{
+			Key:   rec.Key,
+			Value: rec.Value,
+		}
+	}
+
+	ids, retChan := twr.WriteWalRecordsWithOptions(records, opts)
+
+	select {
+	case errs := <-retChan:
+		status := http.StatusCreated
+		if opts.Ack == AckNone {
+			status = http.StatusAccepted
+		}
+
+		resp := &produceBatchResponse{
+			Results: make([]*produceResult, len(errs)),
+		}
+
+		for i, err := range errs {
+			if err != nil {
+				if status == http.StatusCreated || status == http.StatusAccepted {
+					status = walErrorStatus(err)
+				}
+
+				resp.Results[i] = &produceResult{Error: err.Error()}
+				continue
+			}
+
+			resp.Results[i] = &produceResult{WalRecordID: ids[i]}
+		}
+
+		writeJSON(w, status, resp)
+	case <-r.Context().Done():
+		log.Warn("Client went away before batch was written: ", r.Context().Err())
+	}
+}
+
+func (s *WalHTTPServer) handlePartitionRecords(w http.ResponseWriter, r *http.Request, topic string, partition string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", r.Method))
+		return
+	}
+
+	twr := s.Topic(topic)
+	if twr == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No such topic: %s", topic))
+		return
+	}
+
+	p, err := strconv.ParseUint(partition, 10, 32)
+	if err != nil || uint32(p) >= twr.PartitionCount() {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No such partition: %s", partition))
+		return
+	}
+
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid offset: %s", r.URL.Query().Get("offset")))
+		return
+	}
+
+	since, hasSince, err := querySince(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if hasSince && r.URL.Query().Get("offset") != "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Only one of offset and since can be given."))
+		return
+	}
+
+	max, err := queryInt(r, "max", defaultConsumeMax)
+	if err != nil || max <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid max: %s", r.URL.Query().Get("max")))
+		return
+	}
+
+	wait, err := queryInt(r, "wait", 0)
+	if err != nil || wait < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid wait: %s", r.URL.Query().Get("wait")))
+		return
+	}
+
+	timeout := time.Duration(wait) * time.Millisecond
+	if timeout > maxConsumeWait {
+		timeout = maxConsumeWait
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	var cursor *WalPartitionCursor
+	if hasSince {
+		cursor, err = twr.NewReader().PartitionSince(uint32(p), since)
+	} else {
+		cursor, err = twr.NewReader().Partition(uint32(p), offset)
+	}
+
+	if err != nil {
+		writeError(w, walErrorStatus(err), err)
+		return
+	}
+	defer cursor.Close()
+
+	entries, err := cursor.ReadEntries(ctx, int(max))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := &consumeResponse{
+		Records:    make([]*consumedRecord, 0, len(entries)),
+		NextOffset: cursor.Position(),
+	}
+
+	for _, e := range entries {
+		resp.Records = append(resp.Records, newConsumedRecord(e))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func newConsumedRecord(e *WalPartitionEntry) *consumedRecord {
+	return &consumedRecord{
+		Offset:    e.Offset,
+		Partition: e.Record.ID.Partition,
+		Key:       e.Record.Record.Key,
+		Value:     e.Record.Record.Value,
+		Timestamp: e.Record.ID.Timestamp,
+		Sequence:  e.Record.ID.Sequence,
+		CrcOk:     e.CrcOk,
+	}
+}
+
+func queryInt(r *http.Request, name string, def int64) (int64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+
+	return strconv.ParseInt(v, 10, 64)
+}
+
+//ackHeader names the header choosing the ack level of a produce request.
+const ackHeader = "X-Ack"
+
+//writeOptions reads the ack header and the partition parameter which sends the records of a write to that partition.
+func writeOptions(r *http.Request) (WalWriteOptions, error) {
+	opts := WalWriteOptions{
+		Ack: WalAckLevel(r.Header.Get(ackHeader)),
+	}
+
+	if opts.Ack != "" && !opts.Ack.Valid() {
+		return opts, fmt.Errorf("Invalid %s: %s", ackHeader, opts.Ack)
+	}
+
+	v := r.URL.Query().Get("partition")
+	if v == "" {
+		return opts, nil
+	}
+
+	p, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return opts, fmt.Errorf("Invalid partition: %s", v)
+	}
+
+	opts.Partitioner = ExplicitPartitioner(p)
+	return opts, nil
+}
+
+//querySince parses the since parameter given either as RFC3339 or as unix nanoseconds.
+func querySince(r *http.Request) (int64, bool, error) {
+	v := r.URL.Query().Get("since")
+	if v == "" {
+		return 0, false, nil
+	}
+
+	ret, err := ParseTimestamp(v)
+	if err != nil {
+		return 0, false, fmt.Errorf("Invalid since: %v", err)
+	}
+
+	return ret, true, nil
+}
+
+//ParseTimestamp parses a timestamp given either as RFC3339 or as unix nanoseconds.
+func ParseTimestamp(v string) (int64, error) {
+	nanos, err := strconv.ParseInt(v, 10, 64)
+	if err == nil {
+		return nanos, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return 0, fmt.Errorf("expected RFC3339 or unix nanoseconds: %s", v)
+	}
+
+	return t.UnixNano(), nil
+}
+
+//decodeBody decodes the json body of the request into v, failing once it reads more than records of limit bytes take
+// as json. Values are base64 encoded and keys may be escaped, twice the size leaves room for both. 0 disables the check.
+func decodeBody(w http.ResponseWriter, r *http.Request, limit int64, v interface{}) error {
+	body := r.Body
+	if limit > 0 {
+		body = http.MaxBytesReader(w, r.Body, 2*limit+jsonBodySlack)
+	}
+
+	return json.NewDecoder(body).Decode(v)
+}
+
+//bodyErrorStatus answers 413 for bodies above the limit of decodeBody, 400 for anything else failing to decode.
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusBadRequest
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Warn("Failed to write response: ", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	log.Debug("Request failed: ", status, " ", err)
+	writeJSON(w, status, &errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testServer(t *testing.T) (*WalHTTPServer, *WalTopicWriter, Path) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	topics, err := NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Fatal("Failed to create topic manager: ", err)
+	}
+
+	twr, err := topics.CreateTopic(WalTopicConfig{Name: "Test", PartitionCount: 2})
+	if err != nil {
+		t.Fatal("Failed to create topic: ", err)
+	}
+
+	return NewWalHTTPServer("localhost", 0, topics), twr, dir
+}
+
+func TestHTTPProduceRecord(t *testing.T) {
+	server, _, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/topics/Test/records", strings.NewReader(`{"key":"Hey","value":"CwHf"}`))
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusCreated {
+		t.Error("Expected 201 but found: ", resp.Code, " ", resp.Body.String())
+		return
+	}
+
+	id := &WalRecordID{}
+	err := json.NewDecoder(resp.Body).Decode(id)
+	if err != nil {
+		t.Error("Failed to decode response: ", err)
+		return
+	}
+
+	crc, _ := Crc32([]byte("Hey"))
+	if id.Partition != int32(crc%2) || id.Sequence != 1 || id.Timestamp == 0 {
+		t.Error("Unexpected record id: ", fmt.Sprintf("%+v", id))
+	}
+}
+
+func TestHTTPProduceUnknownTopic(t *testing.T) {
+	server, _, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/topics/Missing/records", strings.NewReader(`{"key":"Hey"}`))
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNotFound {
+		t.Error("Expected 404 but found: ", resp.Code)
+	}
+}
+
+func TestHTTPConsumeRecords(t *testing.T) {
+	server, twr, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	for i := 0; i < 3; i++ {
+		err := <-twr.WriteWalRecord(&WalRecord{Key: "Hey", Value: []byte{byte(i)}})
+		if err != nil {
+			t.Error("Failed to write record: ", err)
+			return
+		}
+	}
+
+	crc, _ := Crc32([]byte("Hey"))
+	url := fmt.Sprint("/topics/Test/partitions/", crc%2, "/records?offset=1&max=5")
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, url, nil))
+
+	if resp.Code != http.StatusOK {
+		t.Error("Expected 200 but found: ", resp.Code, " ", resp.Body.String())
+		return
+	}
+
+	cr := &consumeResponse{}
+	err := json.NewDecoder(resp.Body).Decode(cr)
+	if err != nil {
+		t.Error("Failed to decode response: ", err)
+		return
+	}
+
+	if len(cr.Records) != 2 || cr.NextOffset != 3 {
+		t.Error("Expected 2 records and next offset 3 but found: ", len(cr.Records), " ", cr.NextOffset)
+		return
+	}
+
+	if cr.Records[0].Offset != 1 || cr.Records[0].Value[0] != 1 || !cr.Records[0].CrcOk {
+		t.Error("Unexpected record: ", fmt.Sprintf("%+v", cr.Records[0]))
+	}
+}
+
+func TestHTTPConsumeWaitsForAppend(t *testing.T) {
+	server, twr, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	crc, _ := Crc32([]byte("Hey"))
+	url := fmt.Sprint("/topics/Test/partitions/", crc%2, "/records?wait=5000")
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, url, nil))
+		done <- resp
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	err := <-twr.WriteWalRecord(&WalRecord{Key: "Hey", Value: []byte{1}})
+	if err != nil {
+		t.Error("Failed to write record: ", err)
+		return
+	}
+
+	resp := <-done
+	if time.Since(start) > 2*time.Second {
+		t.Error("Consumer was not woken up by the writer.")
+		return
+	}
+
+	cr := &consumeResponse{}
+	err = json.NewDecoder(resp.Body).Decode(cr)
+	if err != nil || len(cr.Records) != 1 || cr.NextOffset != 1 {
+		t.Error("Expected a single record but found: ", resp.Body.String(), " ", err)
+	}
+}
+
+func TestHTTPProduceBatch(t *testing.T) {
+	server, twr, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	body := `{"records":[{"key":"a","value":"AQ=="},{"key":"b","value":"Ag=="},{"key":"c","value":"Aw=="}]}`
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/topics/Test/records:batch", strings.NewReader(body)))
+
+	if resp.Code != http.StatusCreated {
+		t.Error("Expected 201 but found: ", resp.Code, " ", resp.Body.String())
+		return
+	}
+
+	br := &produceBatchResponse{}
+	err := json.NewDecoder(resp.Body).Decode(br)
+	if err != nil || len(br.Results) != 3 {
+		t.Error("Expected 3 results but found: ", resp.Body.String(), " ", err)
+		return
+	}
+
+	var total int64
+	for i, key := range []string{"a", "b", "c"} {
+		crc, _ := Crc32([]byte(key))
+		if br.Results[i].WalRecordID == nil || br.Results[i].Partition != int32(crc%2) {
+			t.Error("Unexpected result for: ", key, " ", resp.Body.String())
+			return
+		}
+	}
+
+	for p := uint32(0); p < 2; p++ {
+		end, err := twr.NewReader().EndOffset(p)
+		if err != nil {
+			t.Error("Failed to read partition: ", err)
+			return
+		}
+
+		total += end
+	}
+
+	if total != 3 {
+		t.Error("Expected 3 records on disk but found: ", total)
+	}
+}
+
+func TestHTTPTopicAdmin(t *testing.T) {
+	server, _, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/topics", strings.NewReader(`{"name":"Other","partitionCount":4}`)))
+	if resp.Code != http.StatusCreated {
+		t.Error("Expected 201 but found: ", resp.Code, " ", resp.Body.String())
+		return
+	}
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/topics", strings.NewReader(`{"name":"Other","partitionCount":4}`)))
+	if resp.Code != http.StatusConflict {
+		t.Error("Expected 409 but found: ", resp.Code)
+		return
+	}
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/topics/Other", nil))
+	desc := &topicDescription{}
+	err := json.NewDecoder(resp.Body).Decode(desc)
+	if resp.Code != http.StatusOK || err != nil || len(desc.Partitions) != 4 {
+		t.Error("Unexpected description: ", resp.Code, " ", err)
+		return
+	}
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodDelete, "/topics/Other", nil))
+	if resp.Code != http.StatusNoContent {
+		t.Error("Expected 204 but found: ", resp.Code)
+		return
+	}
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/topics", nil))
+	configs := WalTopicsConfig{}
+	err = json.NewDecoder(resp.Body).Decode(&configs)
+	if err != nil || len(configs) != 1 || configs[0].Name != "Test" {
+		t.Error("Unexpected topic list: ", resp.Body.String())
+	}
+}
+
+func TestHTTPProduceRecordTooLarge(t *testing.T) {
+	server, _, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	value := base64.StdEncoding.EncodeToString(make([]byte, 513))
+	req := httptest.NewRequest(http.MethodPost, "/topics/Test/records", strings.NewReader(`{"key":"Hey","value":"`+value+`"}`))
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusRequestEntityTooLarge {
+		t.Error("Expected 413 but found: ", resp.Code, " ", resp.Body.String())
+	}
+
+	//Bodies way above the limits are cut off before being decoded.
+	huge := strings.Repeat(" ", 64*1024)
+	for _, path := range []string{"/topics/Test/records", "/topics/Test/records:batch"} {
+		req = httptest.NewRequest(http.MethodPost, path, strings.NewReader(huge+`{"key":"Hey","value":"CwHf"}`))
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusRequestEntityTooLarge {
+			t.Error("Expected 413 for: ", path, " but found: ", resp.Code, " ", resp.Body.String())
+		}
+	}
+}
+
+func TestHTTPConsumeSince(t *testing.T) {
+	server, twr, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	var ids []*WalRecordID
+	for i := 0; i < 3; i++ {
+		id, retChan := twr.WriteWalRecordWithID(&WalRecord{Key: "Hey", Value: []byte{byte(i)}})
+		err := <-retChan
+		if err != nil {
+			t.Error("Failed to write record: ", err)
+			return
+		}
+
+		ids = append(ids, id)
+		time.Sleep(time.Millisecond)
+	}
+
+	crc, _ := Crc32([]byte("Hey"))
+	since := time.Unix(0, ids[1].Timestamp).UTC().Format(time.RFC3339Nano)
+	for _, v := range []string{since, fmt.Sprint(ids[1].Timestamp)} {
+		url := fmt.Sprint("/topics/Test/partitions/", crc%2, "/records?since=", v)
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, url, nil))
+
+		cr := &consumeResponse{}
+		err := json.NewDecoder(resp.Body).Decode(cr)
+		if resp.Code != http.StatusOK || err != nil {
+			t.Error("Expected 200 but found: ", resp.Code, " ", err)
+			return
+		}
+
+		if len(cr.Records) != 2 || cr.Records[0].Offset != 1 || cr.NextOffset != 3 {
+			t.Error("Expected the records from offset 1 for since: ", v, " but found: ", len(cr.Records), " ", cr.NextOffset)
+			return
+		}
+	}
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/topics/Test/partitions/0/records?since=yesterday", nil))
+	if resp.Code != http.StatusBadRequest {
+		t.Error("Expected 400 but found: ", resp.Code)
+	}
+}
+
+func TestHTTPProduceToPartition(t *testing.T) {
+	server, twr, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	crc, _ := Crc32([]byte("Hey"))
+	target := 1 - crc%2
+
+	for _, tc := range []struct {
+		url    string
+		body   string
+		status int
+	}{
+		{fmt.Sprint("/topics/Test/records?partition=", target), `{"key":"Hey","value":"CwHf"}`, http.StatusCreated},
+		{fmt.Sprint("/topics/Test/records:batch?partition=", target), `{"records":[{"key":"Hey","value":"AQ=="},{"key":"Ho","value":"Ag=="}]}`, http.StatusCreated},
+		{"/topics/Test/records?partition=2", `{"key":"Hey","value":"CwHf"}`, http.StatusBadRequest},
+		{"/topics/Test/records?partition=first", `{"key":"Hey","value":"CwHf"}`, http.StatusBadRequest},
+	} {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, tc.url, strings.NewReader(tc.body)))
+		if resp.Code != tc.status {
+			t.Error("Expected ", tc.status, " for: ", tc.url, " but found: ", resp.Code, " ", resp.Body.String())
+		}
+	}
+
+	end, err := twr.NewReader().EndOffset(target)
+	if err != nil || end != 3 {
+		t.Error("Expected every record on the requested partition but found: ", end, " ", err)
+	}
+}
+
+func TestHTTPIncreasePartitions(t *testing.T) {
+	server, twr, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	for _, tc := range []struct {
+		url    string
+		body   string
+		status int
+	}{
+		{"/topics/Test/partitions", `{"partitionCount":1}`, http.StatusBadRequest},
+		{"/topics/Test/partitions", `{"partitionCount":3,"partitioner":"random"}`, http.StatusBadRequest},
+		{"/topics/Missing/partitions", `{"partitionCount":3}`, http.StatusNotFound},
+		{"/topics/Test/partitions", `{"partitionCount":3,"partitioner":"consistent"}`, http.StatusBadRequest},
+		{"/topics/Test/partitions", `{"partitionCount":3,"partitioner":"crc32"}`, http.StatusOK},
+		{"/topics/Test/records?partition=2", `{"key":"Hey","value":"CwHf"}`, http.StatusCreated},
+	} {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, tc.url, strings.NewReader(tc.body)))
+		if resp.Code != tc.status {
+			t.Error("Expected ", tc.status, " for: ", tc.url, " ", tc.body, " but found: ", resp.Code, " ", resp.Body.String())
+		}
+	}
+
+	//A file where the directory of the next partition goes fails the increase, not the server.
+	err := ioutil.WriteFile(dir.Add("Test").AddUint32(3).String(), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/topics/Test/partitions", strings.NewReader(`{"partitionCount":4}`)))
+	if resp.Code != http.StatusInternalServerError {
+		t.Error("Expected 500 for a broken partition but found: ", resp.Code, " ", resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/topics/Test", nil))
+
+	desc := &topicDescription{}
+	err = json.NewDecoder(resp.Body).Decode(desc)
+	if err != nil || desc.PartitionCount != 3 || len(desc.Partitions) != 3 || desc.Partitions[2].EndOffset != 1 || twr.PartitionCount() != 3 {
+		t.Error("Unexpected topic after increasing partitions: ", resp.Body.String(), " ", err)
+	}
+}
+
+func TestHTTPProduceAckHeader(t *testing.T) {
+	server, _, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	for _, tc := range []struct {
+		ack    string
+		url    string
+		body   string
+		status int
+	}{
+		{"none", "/topics/Test/records", `{"key":"Hey","value":"CwHf"}`, http.StatusAccepted},
+		{"none", "/topics/Test/records:batch", `{"records":[{"key":"a","value":"AQ=="}]}`, http.StatusAccepted},
+		{"buffered", "/topics/Test/records", `{"key":"Hey","value":"CwHf"}`, http.StatusCreated},
+		{"synced", "/topics/Test/records:batch", `{"records":[{"key":"a","value":"AQ=="}]}`, http.StatusCreated},
+		{"eventually", "/topics/Test/records", `{"key":"Hey","value":"CwHf"}`, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.url, strings.NewReader(tc.body))
+		req.Header.Set(ackHeader, tc.ack)
+
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		if resp.Code != tc.status {
+			t.Error("Expected ", tc.status, " for ack: ", tc.ack, " on: ", tc.url, " but found: ", resp.Code, " ", resp.Body.String())
+		}
+	}
+}
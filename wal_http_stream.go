@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const streamKeepAliveTime = 15 * time.Second
+
+//streamPartitionCheckTime how often a stream looks for partitions added to the topic since it was opened.
+const streamPartitionCheckTime = time.Second
+
+//streamError the data of the error event ending a stream when one of its partitions can no longer be read.
+type streamError struct {
+	Partition uint32 `json:"partition"`
+	Error     string `json:"error"`
+}
+
+func (s *WalHTTPServer) handleStream(w http.ResponseWriter, r *http.Request, topic string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method not allowed: %s", r.Method))
+		return
+	}
+
+	twr := s.Topic(topic)
+	if twr == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No such topic: %s", topic))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("Streaming not supported."))
+		return
+	}
+
+	positions, err := streamStartPositions(twr, r)
+	if _, ok := err.(WalError); ok {
+		writeError(w, walErrorStatus(err), err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := r.Context()
+	events := make(chan *WalPartitionEntry)
+
+	emit := func(e *WalPartitionEntry) bool {
+		select {
+		case events <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	failures := make(chan *streamError)
+	start := func(partition uint32, offset int64) {
+		go func() {
+			err := streamPartition(ctx, twr, partition, offset, emit)
+			if err == nil {
+				return
+			}
+
+			select {
+			case failures <- &streamError{Partition: partition, Error: err.Error()}:
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	var i uint32
+	for i = 0; i < uint32(len(positions)); i++ {
+		start(i, positions[i])
+	}
+
+	keepAlive := time.NewTicker(streamKeepAliveTime)
+	defer keepAlive.Stop()
+
+	partitionCheck := time.NewTicker(streamPartitionCheckTime)
+	defer partitionCheck.Stop()
+
+	for {
+		select {
+		case e := <-events:
+			positions[e.Record.ID.Partition] = e.Offset + 1
+
+			data, err := json.Marshal(newConsumedRecord(e))
+			if err != nil {
+				log.Warn("Failed to encode record: ", err)
+				return
+			}
+
+			_, err = fmt.Fprintf(w, "id: %s\nevent: record\ndata: %s\n\n", formatStreamPosition(positions), data)
+			if err != nil {
+				log.Debug("Stream client went away: ", err)
+				return
+			}
+
+			flusher.Flush()
+
+		case f := <-failures:
+			//Ends the stream, the client reconnects with Last-Event-ID and gets the cause as an http error.
+			log.Warn("Failed to read partition: ", f.Partition, " ", f.Error)
+			data, _ := json.Marshal(f)
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+
+		case <-partitionCheck.C:
+			//Partitions added since are new, every record they hold is streamed.
+			for n := twr.PartitionCount(); uint32(len(positions)) < n; {
+				positions = append(positions, 0)
+				start(uint32(len(positions)-1), 0)
+			}
+
+		case <-keepAlive.C:
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				log.Debug("Stream client went away: ", err)
+				return
+			}
+
+			flusher.Flush()
+
+		case <-ctx.Done():
+			log.Debug("Stream closed: ", ctx.Err())
+			return
+		}
+	}
+}
+
+//streamPartition hands every entry from offset onwards to emit, waiting for new appends, until ctx is done or emit returns false.
+// Returns the error ending the stream of the partition, nil when it was closed.
+func streamPartition(ctx context.Context, twr *WalTopicWriter, partition uint32, offset int64, emit func(*WalPartitionEntry) bool) error {
+	cursor, err := twr.NewReader().Partition(partition, offset)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	for ctx.Err() == nil {
+		e, err := cursor.NextWait(ctx)
+		if err == io.EOF {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if !emit(e) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+//streamStartPositions resumes from Last-Event-ID when present, positions deleted by retention give ErrOffsetOutOfRange. Other partitions start at the first record at or after since
+// when given, otherwise at their end.
+func streamStartPositions(twr *WalTopicWriter, r *http.Request) ([]int64, error) {
+	since, hasSince, err := querySince(r)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := twr.NewReader()
+	ret := make([]int64, twr.PartitionCount())
+	for i := range ret {
+		ret[i] = -1
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID != "" {
+		err := parseStreamPosition(lastEventID, ret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range ret {
+		if ret[i] >= 0 {
+			start, err := reader.StartOffset(uint32(i))
+			if err != nil {
+				return nil, err
+			} else if ret[i] < start {
+				return nil, NewWalError(ErrOffsetOutOfRange, fmt.Sprintf("Offset %d of partition %d is out of range, the partition starts at %d.", ret[i], i, start))
+			}
+
+			continue
+		}
+
+		var offset int64
+		if hasSince {
+			offset, err = reader.OffsetForTime(uint32(i), since)
+		} else {
+			offset, err = reader.EndOffset(uint32(i))
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret[i] = offset
+	}
+
+	return ret, nil
+}
+
+//formatStreamPosition encodes the next offset of every partition as "partition:offset" pairs.
+func formatStreamPosition(positions []int64) string {
+	parts := make([]string, len(positions))
+	for i, p := range positions {
+		parts[i] = fmt.Sprint(i, ":", p)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func parseStreamPosition(s string, positions []int64) error {
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("Invalid stream position: %s", s)
+		}
+
+		p, err := strconv.ParseUint(kv[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid stream position: %s", s)
+		}
+
+		offset, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil || offset < 0 {
+			return fmt.Errorf("Invalid stream position: %s", s)
+		}
+
+		if p < uint64(len(positions)) {
+			positions[p] = offset
+		}
+	}
+
+	return nil
+}
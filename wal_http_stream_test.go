@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamResumesFromLastEventID(t *testing.T) {
+	server, twr, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	keys := []string{"a", "b", "c", "d"}
+	for _, k := range keys {
+		err := <-twr.WriteWalRecord(&WalRecord{Key: k, Value: []byte(k)})
+		if err != nil {
+			t.Error("Failed to write record: ", err)
+			return
+		}
+	}
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, httpServer.URL+"/topics/Test/stream", nil)
+	req.Header.Set("Last-Event-ID", "0:0,1:0")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error("Failed to open stream: ", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Error("Unexpected content type: ", resp.Header.Get("Content-Type"))
+		return
+	}
+
+	seen := map[string]bool{}
+	lastID := ""
+	scanner := bufio.NewScanner(resp.Body)
+	for len(seen) < len(keys) && scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "id: ") {
+			lastID = strings.TrimPrefix(line, "id: ")
+		}
+
+		for _, k := range keys {
+			if strings.HasPrefix(line, "data: ") && strings.Contains(line, `"key":"`+k+`"`) {
+				seen[k] = true
+			}
+		}
+	}
+
+	if len(seen) != len(keys) {
+		t.Error("Expected all records to be streamed but found: ", seen)
+		return
+	}
+
+	positions := make([]int64, 2)
+	err = parseStreamPosition(lastID, positions)
+	if err != nil || positions[0]+positions[1] != int64(len(keys)) {
+		t.Error("Last event id does not cover all records: ", lastID)
+	}
+}
+
+func TestStreamIncludesAddedPartitions(t *testing.T) {
+	server, twr, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(httpServer.URL + "/topics/Test/stream")
+	if err != nil {
+		t.Error("Failed to open stream: ", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	_, err = server.topics.IncreasePartitions("Test", 3, "")
+	if err != nil {
+		t.Error("Failed to increase partitions: ", err)
+		return
+	}
+
+	_, retChan := twr.WriteWalRecordWithOptions(&WalRecord{Key: "new", Value: []byte("new")}, WalWriteOptions{Partitioner: ExplicitPartitioner(2)})
+	if err := <-retChan; err != nil {
+		t.Error("Failed to write record: ", err)
+		return
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "id: ") && !strings.HasSuffix(line, ",2:1") {
+			t.Error("Unexpected event id: ", line)
+			return
+		}
+
+		if strings.HasPrefix(line, "data: ") && strings.Contains(line, `"key":"new"`) {
+			return
+		}
+	}
+
+	t.Error("Record of the added partition was not streamed: ", scanner.Err())
+}
+
+func TestStreamSendsErrorEvent(t *testing.T) {
+	server, twr, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(httpServer.URL + "/topics/Test/stream")
+	if err != nil {
+		t.Error("Failed to open stream: ", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	//The open segment keeps taking writes while its partition can no longer be listed.
+	partitionDir := dir.Add("Test").AddUint32(1).String()
+	err = os.Rename(partitionDir, partitionDir+".moved")
+	if err == nil {
+		err = ioutil.WriteFile(partitionDir, []byte{}, 0644)
+	}
+
+	if err != nil {
+		t.Error("Failed to break partition: ", err)
+		return
+	}
+
+	_, retChan := twr.WriteWalRecordWithOptions(&WalRecord{Key: "a", Value: []byte("a")}, WalWriteOptions{Partitioner: ExplicitPartitioner(1)})
+	if err := <-retChan; err != nil {
+		t.Error("Failed to write record: ", err)
+		return
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if scanner.Text() != "event: error" {
+			continue
+		}
+
+		if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), `data: {"partition":1,"error":`) {
+			t.Error("Unexpected error event: ", scanner.Text())
+			return
+		}
+
+		if scanner.Scan() && scanner.Scan() {
+			t.Error("Stream not closed after the error event: ", scanner.Text())
+		}
+
+		return
+	}
+
+	t.Error("No error event sent: ", scanner.Err())
+}
+
+func TestParseStreamPosition(t *testing.T) {
+	positions := []int64{-1, -1, -1}
+
+	err := parseStreamPosition("0:4,2:9", positions)
+	if err != nil {
+		t.Error("Failed to parse position: ", err)
+		return
+	}
+
+	if positions[0] != 4 || positions[1] != -1 || positions[2] != 9 {
+		t.Error("Unexpected positions: ", positions)
+	}
+
+	if formatStreamPosition([]int64{4, 0, 9}) != "0:4,1:0,2:9" {
+		t.Error("Unexpected formatting: ", formatStreamPosition([]int64{4, 0, 9}))
+	}
+
+	err = parseStreamPosition("garbage", positions)
+	if err == nil {
+		t.Error("Expected invalid position to fail.")
+	}
+}
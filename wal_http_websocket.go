@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	wsSendQueueSize  = 256
+	wsMaxInFlight    = 1024
+	wsWriteTimeout   = 10 * time.Second
+	wsFrameProduce   = "produce"
+	wsFrameSubscribe = "subscribe"
+	wsFrameUnsub     = "unsubscribe"
+	wsFrameAck       = "ack"
+	wsFrameRecord    = "record"
+	wsFrameError     = "error"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+//wsFrame is the json message exchanged in both directions over the socket.
+type wsFrame struct {
+	Type      string          `json:"type"`
+	ID        string          `json:"id,omitempty"`
+	Topic     string          `json:"topic,omitempty"`
+	Partition *uint32         `json:"partition,omitempty"`
+	Offset    *int64          `json:"offset,omitempty"`
+	Key       string          `json:"key,omitempty"`
+	Value     []byte          `json:"value,omitempty"`
+	RecordID  *WalRecordID    `json:"recordId,omitempty"`
+	Record    *consumedRecord `json:"record,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+//wsConnection owns a single socket. Everything sent to the client goes through the bounded send queue
+// drained by one writer goroutine, so a slow client only ever stalls its own acks and subscriptions.
+type wsConnection struct {
+	server   *WalHTTPServer
+	conn     *websocket.Conn
+	send     chan *wsFrame
+	inFlight chan struct{}
+
+	ctx           context.Context
+	cancel        context.CancelFunc
+	mutex         sync.Mutex
+	subscriptions map[string]*wsSubscription
+}
+
+//wsSubscription a partition streamed to the client until cancelled.
+type wsSubscription struct {
+	cancel context.CancelFunc
+}
+
+func (s *WalHTTPServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Warn("Failed to upgrade connection: ", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &wsConnection{
+		server:        s,
+		conn:          conn,
+		send:          make(chan *wsFrame, wsSendQueueSize),
+		inFlight:      make(chan struct{}, wsMaxInFlight),
+		ctx:           ctx,
+		cancel:        cancel,
+		subscriptions: make(map[string]*wsSubscription),
+	}
+
+	//A frame holds a single record, limited like the body of a produce request. The socket is closed with
+	// a message too big close frame once exceeded.
+	if limit := s.topics.Defaults().MaxBatchSize; limit > 0 {
+		conn.SetReadLimit(2*limit + jsonBodySlack)
+	}
+
+	log.Debug("Opened websocket: ", conn.RemoteAddr())
+	go c.writeLoop()
+	c.readLoop()
+}
+
+func (c *wsConnection) readLoop() {
+	defer func() {
+		c.cancel()
+		c.conn.Close()
+		log.Debug("Closed websocket: ", c.conn.RemoteAddr())
+	}()
+
+	for {
+		f := &wsFrame{}
+		err := c.conn.ReadJSON(f)
+		if err != nil {
+			log.Debug("Websocket read failed: ", err)
+			return
+		}
+
+		switch f.Type {
+		case wsFrameProduce:
+			c.produce(f)
+		case wsFrameSubscribe:
+			c.subscribe(f)
+		case wsFrameUnsub:
+			c.unsubscribe(f)
+		default:
+			c.enqueue(wsErrorFrame(f.ID, fmt.Errorf("Unknown frame type: %s", f.Type)))
+		}
+	}
+}
+
+func (c *wsConnection) writeLoop() {
+	for {
+		select {
+		case f := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			err := c.conn.WriteJSON(f)
+			if err != nil {
+				log.Debug("Websocket write failed: ", err)
+				c.cancel()
+				c.conn.Close()
+				return
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+//enqueue blocks while the send queue is full. Returns false once the connection is gone.
+func (c *wsConnection) enqueue(f *wsFrame) bool {
+	select {
+	case c.send <- f:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+func (c *wsConnection) produce(f *wsFrame) {
+	twr := c.server.Topic(f.Topic)
+	if twr == nil {
+		c.enqueue(wsErrorFrame(f.ID, fmt.Errorf("No such topic: %s", f.Topic)))
+		return
+	}
+
+	//Stop reading from the socket while too many writes are unacknowledged.
+	select {
+	case c.inFlight <- struct{}{}:
+	case <-c.ctx.Done():
+		return
+	}
+
+	opts := WalWriteOptions{}
+	if f.Partition != nil {
+		opts.Partitioner = ExplicitPartitioner(*f.Partition)
+	}
+
+	id, retChan := twr.WriteWalRecordWithOptions(&WalRecord{
+		Key:   f.Key,
+		Value: f.Value,
+	}, opts)
+
+	go func() {
+		defer func() { <-c.inFlight }()
+
+		err := <-retChan
+		if err != nil {
+			c.enqueue(wsErrorFrame(f.ID, err))
+			return
+		}
+
+		c.enqueue(&wsFrame{
+			Type:     wsFrameAck,
+			ID:       f.ID,
+			Topic:    f.Topic,
+			RecordID: id,
+		})
+	}()
+}
+
+func (c *wsConnection) subscribe(f *wsFrame) {
+	twr := c.server.Topic(f.Topic)
+	if twr == nil {
+		c.enqueue(wsErrorFrame(f.ID, fmt.Errorf("No such topic: %s", f.Topic)))
+		return
+	}
+
+	if f.Partition == nil || *f.Partition >= twr.PartitionCount() {
+		c.enqueue(wsErrorFrame(f.ID, fmt.Errorf("Invalid partition for topic: %s", f.Topic)))
+		return
+	}
+
+	partition := *f.Partition
+	key := fmt.Sprint(f.Topic, "/", partition)
+	c.mutex.Lock()
+	_, ok := c.subscriptions[key]
+	c.mutex.Unlock()
+	if ok {
+		c.enqueue(wsErrorFrame(f.ID, fmt.Errorf("Already subscribed to: %s", key)))
+		return
+	}
+
+	var offset int64
+	if f.Offset != nil {
+		offset = *f.Offset
+		start, err := twr.NewReader().StartOffset(partition)
+		if err == nil && offset < start {
+			err = NewWalError(ErrOffsetOutOfRange, fmt.Sprintf("Offset %d is out of range, the partition starts at %d.", offset, start))
+		}
+
+		if err != nil {
+			c.enqueue(wsErrorFrame(f.ID, err))
+			return
+		}
+	} else {
+		end, err := twr.NewReader().EndOffset(partition)
+		if err != nil {
+			c.enqueue(wsErrorFrame(f.ID, err))
+			return
+		}
+
+		offset = end
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	sub := &wsSubscription{cancel: cancel}
+	c.mutex.Lock()
+	c.subscriptions[key] = sub
+	c.mutex.Unlock()
+
+	log.Debug("Subscribed websocket to: ", key, " from offset: ", offset)
+	go func() {
+		err := streamPartition(ctx, twr, partition, offset, func(e *WalPartitionEntry) bool {
+			return c.enqueue(&wsFrame{
+				Type:   wsFrameRecord,
+				Topic:  f.Topic,
+				Record: newConsumedRecord(e),
+			})
+		})
+
+		if err == nil {
+			return
+		}
+
+		//Drops the subscription so that the client may subscribe again, once told why it ended.
+		log.Warn("Failed to read partition: ", key, " ", err)
+		c.mutex.Lock()
+		if c.subscriptions[key] == sub {
+			delete(c.subscriptions, key)
+		}
+		c.mutex.Unlock()
+		cancel()
+
+		ef := wsErrorFrame(f.ID, err)
+		ef.Topic = f.Topic
+		ef.Partition = &partition
+		c.enqueue(ef)
+	}()
+}
+
+func (c *wsConnection) unsubscribe(f *wsFrame) {
+	if f.Partition == nil {
+		c.enqueue(wsErrorFrame(f.ID, fmt.Errorf("Missing partition.")))
+		return
+	}
+
+	key := fmt.Sprint(f.Topic, "/", *f.Partition)
+	c.mutex.Lock()
+	sub, ok := c.subscriptions[key]
+	delete(c.subscriptions, key)
+	c.mutex.Unlock()
+
+	if !ok {
+		c.enqueue(wsErrorFrame(f.ID, fmt.Errorf("Not subscribed to: %s", key)))
+		return
+	}
+
+	sub.cancel()
+}
+
+func wsErrorFrame(id string, err error) *wsFrame {
+	return &wsFrame{
+		Type:  wsFrameError,
+		ID:    id,
+		Error: err.Error(),
+	}
+}
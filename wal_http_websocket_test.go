@@ -0,0 +1,216 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketProduceAndSubscribe(t *testing.T) {
+	server, _, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(strings.Replace(httpServer.URL, "http", "ws", 1)+"/ws", nil)
+	if err != nil {
+		t.Error("Failed to dial: ", err)
+		return
+	}
+	defer conn.Close()
+
+	crc, _ := Crc32([]byte("Hey"))
+	partition := crc % 2
+	var offset int64
+
+	err = conn.WriteJSON(&wsFrame{Type: wsFrameSubscribe, Topic: "Test", Partition: &partition, Offset: &offset})
+	if err != nil {
+		t.Error("Failed to subscribe: ", err)
+		return
+	}
+
+	err = conn.WriteJSON(&wsFrame{Type: wsFrameProduce, ID: "1", Topic: "Test", Key: "Hey", Value: []byte("there")})
+	if err != nil {
+		t.Error("Failed to produce: ", err)
+		return
+	}
+
+	var ack, record *wsFrame
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for ack == nil || record == nil {
+		f := &wsFrame{}
+		err = conn.ReadJSON(f)
+		if err != nil {
+			t.Error("Failed to read frame: ", err)
+			return
+		}
+
+		switch f.Type {
+		case wsFrameAck:
+			ack = f
+		case wsFrameRecord:
+			record = f
+		default:
+			t.Error("Unexpected frame: ", f.Type, " ", f.Error)
+			return
+		}
+	}
+
+	if ack.ID != "1" || ack.RecordID == nil || uint32(ack.RecordID.Partition) != partition {
+		t.Error("Unexpected ack: ", ack)
+	}
+
+	if record.Record.Key != "Hey" || string(record.Record.Value) != "there" || record.Record.Offset != 0 {
+		t.Error("Unexpected record: ", record.Record)
+	}
+}
+
+func TestWebSocketRejectsHugeFrames(t *testing.T) {
+	server, _, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(strings.Replace(httpServer.URL, "http", "ws", 1)+"/ws", nil)
+	if err != nil {
+		t.Error("Failed to dial: ", err)
+		return
+	}
+	defer conn.Close()
+
+	err = conn.WriteJSON(&wsFrame{Type: wsFrameProduce, ID: "1", Topic: "Test", Key: "Hey", Value: make([]byte, 5*testTopicDefaults().MaxBatchSize)})
+	if err != nil {
+		t.Error("Failed to produce: ", err)
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	err = conn.ReadJSON(&wsFrame{})
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Error("Expected the socket to be closed as message too big but found: ", err)
+	}
+}
+
+func TestWebSocketReportsFailedSubscriptions(t *testing.T) {
+	server, _, dir := testServer(t)
+	defer os.RemoveAll(dir.String())
+	defer server.topics.Close()
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(strings.Replace(httpServer.URL, "http", "ws", 1)+"/ws", nil)
+	if err != nil {
+		t.Error("Failed to dial: ", err)
+		return
+	}
+	defer conn.Close()
+
+	partition := uint32(1)
+	err = conn.WriteJSON(&wsFrame{Type: wsFrameSubscribe, ID: "1", Topic: "Test", Partition: &partition})
+	if err != nil {
+		t.Error("Failed to subscribe: ", err)
+		return
+	}
+
+	//Frames are handled in order, the subscription is running once the record arrives.
+	err = conn.WriteJSON(&wsFrame{Type: wsFrameProduce, ID: "0", Topic: "Test", Partition: &partition, Value: []byte("0")})
+	if err != nil {
+		t.Error("Failed to produce: ", err)
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		f := &wsFrame{}
+		err = conn.ReadJSON(f)
+		if err != nil || f.Type == wsFrameError {
+			t.Error("Failed to read frame: ", err, " ", f.Error)
+			return
+		}
+
+		if f.Type == wsFrameRecord {
+			break
+		}
+	}
+
+	//The open segment keeps taking writes while its partition can no longer be listed.
+	partitionDir := dir.Add("Test").AddUint32(partition).String()
+	err = os.Rename(partitionDir, partitionDir+".moved")
+	if err == nil {
+		err = ioutil.WriteFile(partitionDir, []byte{}, 0644)
+	}
+
+	if err != nil {
+		t.Error("Failed to break partition: ", err)
+		return
+	}
+
+	err = conn.WriteJSON(&wsFrame{Type: wsFrameProduce, ID: "2", Topic: "Test", Partition: &partition, Value: []byte("a")})
+	if err != nil {
+		t.Error("Failed to produce: ", err)
+		return
+	}
+
+	for {
+		f := &wsFrame{}
+		err = conn.ReadJSON(f)
+		if err != nil {
+			t.Error("Failed to read frame: ", err)
+			return
+		}
+
+		if f.Type != wsFrameError {
+			continue
+		}
+
+		if f.ID != "1" || f.Topic != "Test" || f.Partition == nil || *f.Partition != partition {
+			t.Error("Unexpected error frame: ", f)
+			return
+		}
+
+		break
+	}
+
+	os.Remove(partitionDir)
+	os.Rename(partitionDir+".moved", partitionDir)
+
+	err = conn.WriteJSON(&wsFrame{Type: wsFrameSubscribe, ID: "3", Topic: "Test", Partition: &partition})
+	if err != nil {
+		t.Error("Failed to subscribe: ", err)
+		return
+	}
+
+	err = conn.WriteJSON(&wsFrame{Type: wsFrameProduce, ID: "4", Topic: "Test", Partition: &partition, Value: []byte("b")})
+	if err != nil {
+		t.Error("Failed to produce: ", err)
+		return
+	}
+
+	for {
+		f := &wsFrame{}
+		err = conn.ReadJSON(f)
+		if err != nil {
+			t.Error("Failed to read frame: ", err)
+			return
+		}
+
+		if f.Type == wsFrameError {
+			t.Error("Subscribing again failed: ", f.Error)
+			return
+		}
+
+		if f.Type == wsFrameRecord && string(f.Record.Value) == "b" {
+			return
+		}
+	}
+}
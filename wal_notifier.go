@@ -0,0 +1,36 @@
+package main
+
+import (
+	"sync"
+)
+
+//WalNotifier wakes up readers waiting for new entries on a partition.
+type WalNotifier struct {
+	mutex sync.Mutex
+	ch    chan struct{}
+}
+
+//NewWalNotifier creates a new WalNotifier
+func NewWalNotifier() *WalNotifier {
+	return &WalNotifier{
+		ch: make(chan struct{}),
+	}
+}
+
+//Wait returns a channel that gets closed on the next Notify.
+// Grab the channel before checking for data so that no append is missed.
+func (n *WalNotifier) Wait() <-chan struct{} {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.ch
+}
+
+//Notify wakes up all current waiters.
+func (n *WalNotifier) Notify() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	close(n.ch)
+	n.ch = make(chan struct{})
+}
@@ -2,8 +2,8 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
-	"errors"
 	"io"
 	"os"
 
@@ -20,6 +20,8 @@ type WalPartitionReader struct {
 	Reader *bufio.Reader
 
 	CurrentOffset int64
+	MaxEntrySize  int64
+	Notifier      *WalNotifier
 }
 
 //NewWalPartitionReader creates a new WalPartitionReader
@@ -70,6 +72,10 @@ func (w *WalPartitionReader) ReadNextEntry() (*WalExRecord, int64, error) {
 	//Succeeded in reading size.
 	w.CurrentOffset += int64(n)
 	sz := binary.LittleEndian.Uint32(size)
+	if w.MaxEntrySize > 0 && int64(sz) > w.MaxEntrySize {
+		//Garbage size prefix, do not trust it with an allocation.
+		return nil, w.CurrentOffset, ErrEntryTooLarge
+	}
 	buff := make([]byte, sz)
 	n, err = io.ReadFull(w.Reader, buff)
 	if err == io.ErrUnexpectedEOF {
@@ -89,18 +95,63 @@ func (w *WalPartitionReader) ReadNextEntry() (*WalExRecord, int64, error) {
 	_, err = wr.Write(buff)
 	if err != nil {
 		//Unlikely to happen
-		return nil, w.CurrentOffset, nil
+		return nil, w.CurrentOffset, err
 	}
 
 	//Check for Crc32.
 	crc, err := Crc32(buff[:(sz - uint32(binary.Size(size)))])
 	if crc != wr.Crc {
-		return wr, w.CurrentOffset, errors.New("Wrong Checksum")
+		return wr, w.CurrentOffset, ErrWrongChecksum
 	}
 
 	return wr, w.CurrentOffset, nil
 }
 
+//ReadNextEntryWait reads the next entry, waiting for the writer to append one when at the end of the segment.
+// Returns io.EOF if nothing was appended before ctx is done or when no Notifier is set.
+func (w *WalPartitionReader) ReadNextEntryWait(ctx context.Context) (*WalExRecord, int64, error) {
+	for {
+		var wake <-chan struct{}
+		if w.Notifier != nil {
+			wake = w.Notifier.Wait()
+		}
+
+		start := w.CurrentOffset
+		wr, offset, err := w.ReadNextEntry()
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return wr, offset, err
+		}
+
+		//Nothing or only part of an entry has been flushed so far, retry from the entry start.
+		err = w.SeekTo(start)
+		if err != nil {
+			return nil, start, err
+		}
+
+		if wake == nil {
+			return nil, start, io.EOF
+		}
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, start, io.EOF
+		}
+	}
+}
+
+//SeekTo moves the reader to the byte offset in the segment.
+func (w *WalPartitionReader) SeekTo(offset int64) error {
+	_, err := w.File.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	w.Reader.Reset(w.File)
+	w.CurrentOffset = offset
+	return nil
+}
+
 //Close closes the underlaying file handle.
 func (w *WalPartitionReader) Close() {
 
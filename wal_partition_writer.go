@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"encoding/binary"
+	"io"
 	"os"
 	"sync"
 
@@ -13,6 +14,7 @@ import (
 type WalPartitionWriter struct {
 	mutex          sync.Mutex
 	MaxSegmentSize int64
+	MaxEntrySize   int64
 
 	File   *os.File
 	Writer *bufio.Writer
@@ -20,11 +22,52 @@ type WalPartitionWriter struct {
 	WalSyncType   WalSyncType
 	CurrentOffset int64
 	DirPath       *Path
+
+	BaseOffset   int64
+	NextOffset   int64
+	IndexFile    *os.File
+	pendingIndex []WalIndexEntry
+
+	MaxTimestamp     int64
+	TimeIndexFile    *os.File
+	pendingTimeIndex []WalTimeIndexEntry
+	timeIndexEnd     int64
+
+	//indexFailed stops appending to the indexes after a failed write, they get rebuilt from the segment on close.
+	indexFailed bool
 }
 
-//NewWalPartitionWriter creates a new WalPartitionWriter
-func NewWalPartitionWriter(filePath string, maxSegmentSize int64, walSyncType WalSyncType) (*WalPartitionWriter, error) {
-	log.Debugf("Creating new partition writer: filePath:%s, maxSegmentSize: %d, walSyncType: %s", filePath, maxSegmentSize, walSyncType)
+//NewWalPartitionWriter creates a new WalPartitionWriter. maxEntrySize bounds the entries considered valid when recovering, 0 disables it.
+// baseOffset is the logical offset of the first entry of a new segment, an existing segment keeps the one stored in its index.
+func NewWalPartitionWriter(filePath string, baseOffset int64, maxSegmentSize int64, maxEntrySize int64, walSyncType WalSyncType) (*WalPartitionWriter, error) {
+	log.Debugf("Creating new partition writer: filePath:%s, baseOffset: %d, maxSegmentSize: %d, maxEntrySize: %d, walSyncType: %s", filePath, baseOffset, maxSegmentSize, maxEntrySize, walSyncType)
+
+	//The index has to exist before the segment does, readers rely on it for the segment base offset.
+	idx, err := ReadSegmentIndex(filePath)
+	if os.IsNotExist(err) {
+		idx, _, err = BuildSegmentIndex(filePath, baseOffset, maxEntrySize)
+		if err == nil {
+			err = WriteSegmentIndex(filePath, idx)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	//So does the time index, a reader rebuilding a missing one would replace the file appended to below.
+	_, err = ReadSegmentTimeIndex(filePath)
+	if os.IsNotExist(err) {
+		var timeIndex *WalSegmentTimeIndex
+		timeIndex, _, err = BuildSegmentTimeIndex(filePath, idx, maxEntrySize)
+		if err == nil {
+			err = WriteSegmentTimeIndex(filePath, timeIndex)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
 
 	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
@@ -32,12 +75,50 @@ func NewWalPartitionWriter(filePath string, maxSegmentSize int64, walSyncType Wa
 	}
 
 	log.Debug("Opened file: ", filePath)
-	offset, err := MoveToLastValidWalEntry(file, maxSegmentSize)
+	//Drop whatever follows the last valid entry so appends continue right after it.
+	_, err = RecoverWalSegment(file, maxEntrySize, false)
 	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
 	log.Debug("Moved offset file to: ", offset)
+	nextOffset, err := recoverSegmentIndex(filePath, idx, offset, maxEntrySize)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	timeIndex, maxTimestamp, err := recoverSegmentTimeIndex(filePath, idx, nextOffset, maxEntrySize)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	indexFile, err := os.OpenFile(SegmentIndexPath(filePath), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	timeIndexFile, err := os.OpenFile(SegmentTimeIndexPath(filePath), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		indexFile.Close()
+		file.Close()
+		return nil, err
+	}
+
+	timeIndexEnd := int64(-1)
+	if last, ok := timeIndex.Last(); ok {
+		timeIndexEnd = last.Offset
+	}
+
 	dirPath := Path(filePath).BaseDir()
 
 	ret := &WalPartitionWriter{
@@ -47,11 +128,89 @@ func NewWalPartitionWriter(filePath string, maxSegmentSize int64, walSyncType Wa
 		WalSyncType:    walSyncType,
 		DirPath:        &dirPath,
 		MaxSegmentSize: maxSegmentSize,
+		MaxEntrySize:   maxEntrySize,
+		BaseOffset:     idx.BaseOffset,
+		NextOffset:     nextOffset,
+		IndexFile:      indexFile,
+		MaxTimestamp:   maxTimestamp,
+		TimeIndexFile:  timeIndexFile,
+		timeIndexEnd:   timeIndexEnd,
 	}
 
 	return ret, nil
 }
 
+//recoverSegmentIndex drops index entries pointing past the valid end of the segment and returns the next logical offset.
+func recoverSegmentIndex(filePath string, idx *WalSegmentIndex, validEnd int64, maxEntrySize int64) (int64, error) {
+	valid := len(idx.Entries)
+	for valid > 0 && idx.Entries[valid-1].Position >= validEnd {
+		valid--
+	}
+
+	if valid < len(idx.Entries) {
+		log.Warn("Dropping index entries past the end of segment: ", filePath)
+		idx.Entries = idx.Entries[:valid]
+		err := WriteSegmentIndex(filePath, idx)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return SegmentEndOffset(filePath, idx, maxEntrySize)
+}
+
+//recoverSegmentTimeIndex drops time index entries past the end of the segment and returns the highest timestamp in it.
+// A missing time index, or one lagging behind the offset index, is rebuilt from the segment.
+func recoverSegmentTimeIndex(filePath string, idx *WalSegmentIndex, nextOffset int64, maxEntrySize int64) (*WalSegmentTimeIndex, int64, error) {
+	timeIndex, err := ReadSegmentTimeIndex(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, 0, err
+	}
+
+	lastOffset := int64(-1)
+	if err == nil {
+		valid := len(timeIndex.Entries)
+		for valid > 0 && timeIndex.Entries[valid-1].Offset > nextOffset {
+			valid--
+		}
+
+		if valid < len(timeIndex.Entries) {
+			log.Warn("Dropping time index entries past the end of segment: ", filePath)
+			timeIndex.Entries = timeIndex.Entries[:valid]
+			err = WriteSegmentTimeIndex(filePath, timeIndex)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+
+		if last, ok := timeIndex.Last(); ok {
+			lastOffset = last.Offset
+		}
+	}
+
+	if timeIndex == nil || (nextOffset > idx.BaseOffset && lastOffset < idx.Last().Offset) {
+		timeIndex, maxTimestamp, err := BuildSegmentTimeIndex(filePath, idx, maxEntrySize)
+		if err == nil {
+			err = WriteSegmentTimeIndex(filePath, timeIndex)
+		}
+
+		return timeIndex, maxTimestamp, err
+	}
+
+	var maxTimestamp int64
+	if last, ok := timeIndex.Last(); ok {
+		maxTimestamp = last.Timestamp
+	}
+
+	_, err = ScanSegment(filePath, idx, idx.Last(), maxEntrySize, func(offset int64, position int64, wr *WalExRecord, crcOk bool) {
+		if wr.ID.Timestamp > maxTimestamp {
+			maxTimestamp = wr.ID.Timestamp
+		}
+	})
+
+	return timeIndex, maxTimestamp, err
+}
+
 func (w *WalPartitionWriter) Write(p []byte) (n int, err error) {
 	log.Debug("Locking for writing.")
 
@@ -89,7 +248,24 @@ func (w *WalPartitionWriter) Write(p []byte) (n int, err error) {
 	}
 
 	ret += count
+	if (w.NextOffset-w.BaseOffset)%WalIndexInterval == 0 {
+		w.pendingIndex = append(w.pendingIndex, WalIndexEntry{Offset: w.NextOffset, Position: w.CurrentOffset})
+	}
+
+	timestamp := walExRecordTimestamp(p)
+	if w.NextOffset == w.BaseOffset {
+		w.queueTimeIndex(timestamp)
+		w.MaxTimestamp = timestamp
+	} else if (w.NextOffset-w.BaseOffset)%WalIndexInterval == 0 {
+		w.queueTimeIndex(w.MaxTimestamp)
+	}
+
+	if timestamp > w.MaxTimestamp {
+		w.MaxTimestamp = timestamp
+	}
+
 	w.CurrentOffset += int64(ret)
+	w.NextOffset++
 
 	log.Debug("Incremented offset. Current offset: ", w.CurrentOffset)
 	return ret, nil
@@ -97,6 +273,11 @@ func (w *WalPartitionWriter) Write(p []byte) (n int, err error) {
 
 //Flush flushes data to file handle based on options
 func (w *WalPartitionWriter) Flush() error {
+	return w.FlushAndSync(w.WalSyncType == FlushOnCommit)
+}
+
+//FlushAndSync flushes data to the file handle and, with sync, syncs the file to disk.
+func (w *WalPartitionWriter) FlushAndSync(sync bool) error {
 
 	log.Debug("Locking ...")
 	w.mutex.Lock()
@@ -106,24 +287,134 @@ func (w *WalPartitionWriter) Flush() error {
 	err := w.Writer.Flush()
 	if err != nil {
 		log.Warn("Failed to flush to file: ", w.File, " ", err)
+		return err
 	}
 
-	if w.WalSyncType == FlushOnCommit {
+	if sync {
 		log.Debug("Syncing to disk.")
 		err = w.File.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	w.flushIndex()
+	return nil
+}
+
+//Sync syncs the data flushed so far to disk.
+func (w *WalPartitionWriter) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	log.Debug("Syncing to disk.")
+	return w.File.Sync()
+}
+
+//flushIndex appends the index entries of the flushed entries, so the index never points past the data on disk.
+// The data is already written, so failures are only logged. The indexes stop growing and get rebuilt on close.
+func (w *WalPartitionWriter) flushIndex() {
+	if w.indexFailed {
+		w.dropIndex()
+		return
+	}
+
+	if len(w.pendingTimeIndex) > 0 {
+		timeIndex := &WalSegmentTimeIndex{Entries: w.pendingTimeIndex}
+		_, err := w.TimeIndexFile.Write(timeIndex.Bytes())
+		if err != nil {
+			log.Warn("Failed to write time index: ", w.TimeIndexFile.Name(), " ", err)
+			w.dropIndex()
+			return
+		}
+
+		w.pendingTimeIndex = w.pendingTimeIndex[:0]
 	}
 
-	return err
+	if len(w.pendingIndex) == 0 {
+		return
+	}
+
+	idx := &WalSegmentIndex{Entries: w.pendingIndex}
+	_, err := w.IndexFile.Write(idx.Bytes()[walIndexHeaderSize:])
+	if err != nil {
+		log.Warn("Failed to write index: ", w.IndexFile.Name(), " ", err)
+		w.dropIndex()
+		return
+	}
+
+	w.pendingIndex = w.pendingIndex[:0]
+}
+
+//dropIndex gives up on appending to the indexes, entries may have been partially written.
+func (w *WalPartitionWriter) dropIndex() {
+	w.indexFailed = true
+	w.pendingTimeIndex = w.pendingTimeIndex[:0]
+	w.pendingIndex = w.pendingIndex[:0]
 }
 
-//Close closes the underlaying file handle.
+//rebuildIndexes replaces the indexes of the segment with ones built from its data. Expects the files to be closed.
+func (w *WalPartitionWriter) rebuildIndexes() error {
+	log.Warn("Rebuilding indexes of segment: ", w.File.Name())
+	idx, _, err := BuildSegmentIndex(w.File.Name(), w.BaseOffset, w.MaxEntrySize)
+	if err != nil {
+		return err
+	}
+
+	err = WriteSegmentIndex(w.File.Name(), idx)
+	if err != nil {
+		return err
+	}
+
+	timeIndex, _, err := BuildSegmentTimeIndex(w.File.Name(), idx, w.MaxEntrySize)
+	if err != nil {
+		return err
+	}
+
+	return WriteSegmentTimeIndex(w.File.Name(), timeIndex)
+}
+
+func (w *WalPartitionWriter) queueTimeIndex(timestamp int64) {
+	w.pendingTimeIndex = append(w.pendingTimeIndex, WalTimeIndexEntry{Timestamp: timestamp, Offset: w.NextOffset})
+	w.timeIndexEnd = w.NextOffset
+}
+
+//Close closes the underlaying file handle. The time index gets an entry covering the whole segment.
 func (w *WalPartitionWriter) Close() error {
 	log.Debug("Closing writer.")
+	w.mutex.Lock()
+	if w.NextOffset > w.BaseOffset && w.timeIndexEnd < w.NextOffset {
+		w.queueTimeIndex(w.MaxTimestamp)
+	}
+	w.mutex.Unlock()
+
 	w.Flush()
 
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
 	log.Debug("Closing file: ", w.File)
-	return w.File.Close()
+	err := w.IndexFile.Close()
+	if err != nil {
+		log.Warn("Failed to close index: ", w.IndexFile.Name(), " ", err)
+	}
+
+	err = w.TimeIndexFile.Close()
+	if err != nil {
+		log.Warn("Failed to close time index: ", w.TimeIndexFile.Name(), " ", err)
+	}
+
+	err = w.File.Close()
+	if err != nil {
+		return err
+	}
+
+	if w.indexFailed {
+		err = w.rebuildIndexes()
+		if err != nil {
+			log.Warn("Failed to rebuild indexes of segment: ", w.File.Name(), " ", err)
+		}
+	}
+
+	return nil
 }
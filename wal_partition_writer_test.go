@@ -17,12 +17,14 @@ func BenchmarkWriteSpeedNoSync(b *testing.B) {
 	b.ResetTimer()
 
 	tmpFile := path()
-	writer, err := NewWalPartitionWriter(tmpFile, 10000000000000, NoFlush)
+	writer, err := NewWalPartitionWriter(tmpFile, 0, 10000000000000, 0, NoFlush)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	defer func(f string) {
+		os.Remove(SegmentIndexPath(f))
+		os.Remove(SegmentTimeIndexPath(f))
 		err := os.Remove(f)
 		if err != nil {
 			log.Fatal(err)
@@ -46,12 +48,14 @@ func BenchmarkWriteSpeedSync(b *testing.B) {
 	b.ResetTimer()
 
 	tmpFile := path()
-	writer, err := NewWalPartitionWriter(tmpFile, 10000000000000, FlushOnCommit)
+	writer, err := NewWalPartitionWriter(tmpFile, 0, 10000000000000, 0, FlushOnCommit)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	defer func(f string) {
+		os.Remove(SegmentIndexPath(f))
+		os.Remove(SegmentTimeIndexPath(f))
 		err := os.Remove(f)
 		if err != nil {
 			log.Fatal(err)
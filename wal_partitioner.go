@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+//Partitioner names selectable per topic.
+const (
+	Crc32Partitioner      = "crc32"
+	Murmur2Partitioner    = "murmur2"
+	RoundRobinPartitioner = "roundrobin"
+	StickyPartitioner     = "sticky"
+	ConsistentPartitioner = "consistent"
+)
+
+//Partitioner picks the partition of every record of a write. Records written together, like a batch, come in one call.
+// Implementations get called concurrently.
+type Partitioner interface {
+	Partition(records []*WalRecord, partitionCount uint32) ([]uint32, error)
+}
+
+//NewPartitioner returns a new instance of the named built-in partitioner, crc32 when empty.
+func NewPartitioner(name string) (Partitioner, error) {
+	switch name {
+	case "", Crc32Partitioner:
+		return &crc32Partitioner{}, nil
+	case Murmur2Partitioner:
+		return &murmur2Partitioner{}, nil
+	case RoundRobinPartitioner:
+		return &roundRobinPartitioner{}, nil
+	case StickyPartitioner:
+		return &stickyPartitioner{}, nil
+	case ConsistentPartitioner:
+		return &consistentPartitioner{}, nil
+	default:
+		return nil, NewWalError(ErrInvalidTopicConfig, fmt.Sprint("Unknown partitioner: ", name))
+	}
+}
+
+//crc32Partitioner hashes keys with crc32, empty keys included. The default, it keeps keys of existing topics in place.
+type crc32Partitioner struct{}
+
+func (p *crc32Partitioner) Partition(records []*WalRecord, partitionCount uint32) ([]uint32, error) {
+	ret := make([]uint32, len(records))
+	for i, r := range records {
+		crc, err := Crc32([]byte(r.Key))
+		if err != nil {
+			return nil, err
+		}
+
+		ret[i] = crc % partitionCount
+	}
+
+	return ret, nil
+}
+
+//murmur2Partitioner hashes keys the way Kafka does so that both place a key on the same partition. Records without
+// a key get spread round-robin.
+type murmur2Partitioner struct {
+	next uint32
+}
+
+func (p *murmur2Partitioner) Partition(records []*WalRecord, partitionCount uint32) ([]uint32, error) {
+	ret := make([]uint32, len(records))
+	for i, r := range records {
+		if r.Key == "" {
+			ret[i] = (atomic.AddUint32(&p.next, 1) - 1) % partitionCount
+			continue
+		}
+
+		ret[i] = murmur2PartitionOf(r.Key, partitionCount)
+	}
+
+	return ret, nil
+}
+
+//roundRobinPartitioner ignores keys and sends every record to the next partition.
+type roundRobinPartitioner struct {
+	next uint32
+}
+
+func (p *roundRobinPartitioner) Partition(records []*WalRecord, partitionCount uint32) ([]uint32, error) {
+	last := atomic.AddUint32(&p.next, uint32(len(records)))
+	ret := make([]uint32, len(records))
+	for i := range records {
+		ret[i] = (last - uint32(len(records)) + uint32(i)) % partitionCount
+	}
+
+	return ret, nil
+}
+
+//stickyPartitioner hashes keys like murmur2Partitioner but keeps the records without a key of a write together on one
+// partition, moving to the next partition with every write. Batches of keyless records end up in a single flush.
+type stickyPartitioner struct {
+	next uint32
+}
+
+func (p *stickyPartitioner) Partition(records []*WalRecord, partitionCount uint32) ([]uint32, error) {
+	ret := make([]uint32, len(records))
+	var sticky uint32
+	chosen := false
+	for i, r := range records {
+		if r.Key != "" {
+			ret[i] = murmur2PartitionOf(r.Key, partitionCount)
+			continue
+		}
+
+		if !chosen {
+			sticky = (atomic.AddUint32(&p.next, 1) - 1) % partitionCount
+			chosen = true
+		}
+
+		ret[i] = sticky
+	}
+
+	return ret, nil
+}
+
+//consistentPartitioner places keys with jump consistent hashing, so that growing a topic from n to n+1 partitions only
+// moves 1/(n+1) of the keys, all of them to the new partition. Records without a key get spread round-robin.
+type consistentPartitioner struct {
+	next uint32
+}
+
+func (p *consistentPartitioner) Partition(records []*WalRecord, partitionCount uint32) ([]uint32, error) {
+	ret := make([]uint32, len(records))
+	for i, r := range records {
+		if r.Key == "" {
+			ret[i] = (atomic.AddUint32(&p.next, 1) - 1) % partitionCount
+			continue
+		}
+
+		ret[i] = jumpHash(uint64(murmur2([]byte(r.Key))), partitionCount)
+	}
+
+	return ret, nil
+}
+
+//ExplicitPartitioner sends every record to the given partition.
+type ExplicitPartitioner uint32
+
+//Partition returns the partition for all records, an error if the topic does not have it.
+func (p ExplicitPartitioner) Partition(records []*WalRecord, partitionCount uint32) ([]uint32, error) {
+	if uint32(p) >= partitionCount {
+		return nil, NewWalError(ErrInvalidPartition, fmt.Sprint("No such partition: ", uint32(p)))
+	}
+
+	ret := make([]uint32, len(records))
+	for i := range ret {
+		ret[i] = uint32(p)
+	}
+
+	return ret, nil
+}
+
+func murmur2PartitionOf(key string, partitionCount uint32) uint32 {
+	return (murmur2([]byte(key)) & 0x7fffffff) % partitionCount
+}
+
+//jumpHash is the jump consistent hash of Lamping and Veach, it maps key to one of buckets.
+func jumpHash(key uint64, buckets uint32) uint32 {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+
+	return uint32(b)
+}
+
+//murmur2 is the 32 bit murmur2 hash with the seed Kafka uses to partition keys.
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
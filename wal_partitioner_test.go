@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMurmur2MatchesKafka(t *testing.T) {
+	for key, expected := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"abc":                        479470107,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+	} {
+		if h := int32(murmur2([]byte(key))); h != expected {
+			t.Error("Unexpected murmur2 of: ", key, " expected: ", expected, " found: ", h)
+		}
+	}
+}
+
+func TestBuiltinPartitioners(t *testing.T) {
+	records := []*WalRecord{{Key: ""}, {Key: "foobar"}, {Key: ""}, {Key: ""}}
+	foobar := murmur2PartitionOf("foobar", 3)
+
+	testCases := []struct {
+		name     string
+		expected [][]uint32
+	}{
+		{Murmur2Partitioner, [][]uint32{{0, foobar, 1, 2}, {0, foobar, 1, 2}}},
+		{RoundRobinPartitioner, [][]uint32{{0, 1, 2, 0}, {1, 2, 0, 1}}},
+		{StickyPartitioner, [][]uint32{{0, foobar, 0, 0}, {1, foobar, 1, 1}}},
+	}
+
+	for _, tc := range testCases {
+		p, err := NewPartitioner(tc.name)
+		if err != nil {
+			t.Error("Failed to create partitioner: ", tc.name, " ", err)
+			return
+		}
+
+		for _, expected := range tc.expected {
+			partitions, err := p.Partition(records, 3)
+			if err != nil || !reflect.DeepEqual(partitions, expected) {
+				t.Error(tc.name, " expected: ", expected, " found: ", partitions, " ", err)
+			}
+		}
+	}
+
+	partitions, err := ExplicitPartitioner(2).Partition(records, 3)
+	if err != nil || !reflect.DeepEqual(partitions, []uint32{2, 2, 2, 2}) {
+		t.Error("Unexpected explicit partitions: ", partitions, " ", err)
+	}
+
+	_, err = ExplicitPartitioner(3).Partition(records, 3)
+	if we, ok := err.(WalError); !ok || we.Code() != ErrInvalidPartition {
+		t.Error("Expected a missing partition to fail: ", err)
+	}
+
+	_, err = NewPartitioner("bogus")
+	if we, ok := err.(WalError); !ok || we.Code() != ErrInvalidTopicConfig {
+		t.Error("Expected an unknown partitioner to fail: ", err)
+	}
+}
+
+func TestConsistentPartitionerMovesKeysToNewPartitionsOnly(t *testing.T) {
+	p, err := NewPartitioner(ConsistentPartitioner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := make([]*WalRecord, 1000)
+	for i := range records {
+		records[i] = &WalRecord{Key: fmt.Sprint("key-", i)}
+	}
+
+	before, _ := p.Partition(records, 4)
+	after, _ := p.Partition(records, 5)
+
+	moved := 0
+	for i := range records {
+		if before[i] == after[i] {
+			continue
+		}
+
+		moved++
+		if after[i] != 4 {
+			t.Error("Expected key to move to the new partition: ", records[i].Key, " moved to: ", after[i])
+			return
+		}
+	}
+
+	if moved == 0 || moved > 300 {
+		t.Error("Expected about a fifth of the keys to move but found: ", moved)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//WalRecoveryReport describes the corrupted tail dropped from a segment when it got reopened.
+type WalRecoveryReport struct {
+	Segment        string    `json:"segment"`
+	ValidBytes     int64     `json:"validBytes"`
+	DroppedBytes   int64     `json:"droppedBytes"`
+	DroppedRecords int64     `json:"droppedRecords"`
+	Quarantine     string    `json:"quarantine,omitempty"`
+	Time           time.Time `json:"time"`
+}
+
+//RecoverWalSegment truncates the segment after its last entry passing the crc check and leaves the file positioned there.
+// Returns nil when nothing had to be dropped. With quarantine the dropped bytes are first copied next to the segment.
+func RecoverWalSegment(file *os.File, maxEntrySize int64, quarantine bool) (*WalRecoveryReport, error) {
+	validEnd, err := MoveToLastValidWalEntry(file, maxEntrySize)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret *WalRecoveryReport
+	if info.Size() > validEnd {
+		ret = &WalRecoveryReport{
+			Segment:      file.Name(),
+			ValidBytes:   validEnd,
+			DroppedBytes: info.Size() - validEnd,
+			Time:         time.Now(),
+		}
+
+		ret.DroppedRecords, err = countTailEntries(file, validEnd, maxEntrySize)
+		if err != nil {
+			return nil, err
+		}
+
+		if quarantine {
+			ret.Quarantine, err = quarantineTail(file, validEnd)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		log.Warnf("Dropping corrupted tail of segment: %s at: %d, bytes: %d, records: %d, quarantine: %s",
+			ret.Segment, validEnd, ret.DroppedBytes, ret.DroppedRecords, ret.Quarantine)
+
+		err = file.Truncate(validEnd)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = file.Seek(validEnd, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+//countTailEntries counts the entries framed by their size prefix from offset on, a partial one included.
+func countTailEntries(file *os.File, offset int64, maxEntrySize int64) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var ret int64
+	size := []byte{0, 0, 0, 0}
+	for offset < info.Size() {
+		ret++
+
+		n, err := file.ReadAt(size, offset)
+		if n < len(size) {
+			break
+		} else if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		sz := int64(binary.LittleEndian.Uint32(size))
+		if maxEntrySize > 0 && sz > maxEntrySize {
+			break
+		}
+
+		offset += int64(len(size)) + sz
+	}
+
+	return ret, nil
+}
+
+//quarantineTail copies everything from offset on to a quarantine file next to the segment.
+func quarantineTail(file *os.File, offset int64) (string, error) {
+	name := fmt.Sprint(strings.TrimSuffix(file.Name(), ".wal"), ".", time.Now().UnixNano(), ".quarantine")
+
+	q, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(q, io.NewSectionReader(file, offset, 1<<62))
+	if err == nil {
+		err = q.Sync()
+	}
+
+	closeErr := q.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(name)
+		return "", err
+	}
+
+	return name, nil
+}
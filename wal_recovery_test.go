@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecoveryQuarantinesCorruptedTail(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	defaults := testTopicDefaults()
+	defaults.Quarantine = true
+
+	topics, err := NewWalTopicManager(dir, defaults)
+	if err != nil {
+		t.Fatal("Failed to create topic manager: ", err)
+	}
+
+	twr, err := topics.CreateTopic(WalTopicConfig{Name: "Test", PartitionCount: 1})
+	if err != nil {
+		t.Fatal("Failed to create topic: ", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err := <-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte("v")})
+		if err != nil {
+			t.Fatal("Failed to write record: ", err)
+		}
+	}
+
+	topics.Close()
+
+	//A complete entry failing the crc check followed by a torn one.
+	segment, _ := ReturnLastCreatedWalFile(dir.Add("Test").AddUint32(0).String())
+	valid, _ := ioutil.ReadFile(*segment)
+	entry := append([]byte{}, valid[len(valid)/2:]...)
+	entry[len(entry)-1] ^= 0xFF
+	tail := append(entry, 1, 0, 0, 0, 7)
+
+	f, err := os.OpenFile(*segment, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write(tail)
+	f.Close()
+
+	topics, err = NewWalTopicManager(dir, defaults)
+	if err != nil {
+		t.Fatal("Failed to reopen topic manager: ", err)
+	}
+	defer topics.Close()
+
+	resp := httptest.NewRecorder()
+	NewWalHTTPServer("localhost", 0, topics).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/topics/Test", nil))
+
+	desc := &topicDescription{}
+	err = json.NewDecoder(resp.Body).Decode(desc)
+	if err != nil || desc.Partitions[0].Recovery == nil {
+		t.Error("Expected a recovery report but found: ", resp.Body.String(), " ", err)
+		return
+	}
+
+	report := desc.Partitions[0].Recovery
+	if report.ValidBytes != int64(len(valid)) || report.DroppedBytes != int64(len(tail)) || report.DroppedRecords != 2 || desc.Partitions[0].EndOffset != 2 {
+		t.Error("Unexpected recovery report: ", resp.Body.String())
+		return
+	}
+
+	quarantined, err := ioutil.ReadFile(report.Quarantine)
+	if err != nil || string(quarantined) != string(tail) {
+		t.Error("Unexpected quarantine content: ", err)
+		return
+	}
+
+	info, err := os.Stat(*segment)
+	if err != nil || info.Size() != int64(len(valid)) {
+		t.Error("Expected the segment to be truncated to: ", len(valid), " ", err)
+	}
+}
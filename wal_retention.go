@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//WalRetention limits how much data a partition keeps. Zero values disable a limit.
+// MaxAge applies to the newest record of a segment, MaxBytes and MaxSegments to the partition as a whole.
+type WalRetention struct {
+	MaxAge      time.Duration
+	MaxBytes    int64
+	MaxSegments int
+}
+
+//Enabled returns true if any limit is set.
+func (r WalRetention) Enabled() bool {
+	return r.MaxAge > 0 || r.MaxBytes > 0 || r.MaxSegments > 0
+}
+
+//EnforcePartitionRetention deletes the oldest segments of the partition as long as they break a retention limit.
+// The newest segment is the one the writer appends to and is never deleted, so the log start offset moves to the
+// base offset of the first segment left. Returns the number of deleted segments.
+func EnforcePartitionRetention(partitionDir Path, retention WalRetention, maxEntrySize int64, now time.Time) (int, error) {
+	if !retention.Enabled() {
+		return 0, nil
+	}
+
+	segments, err := LoadPartitionSegments(partitionDir, maxEntrySize)
+	if err != nil {
+		return 0, err
+	}
+
+	sizes := make([]int64, len(segments))
+	var total int64
+	for i, s := range segments {
+		info, err := os.Stat(s.Path)
+		if err != nil {
+			return 0, err
+		}
+
+		sizes[i] = info.Size()
+		total += sizes[i]
+	}
+
+	deleted := 0
+	for i := 0; i+1 < len(segments); i++ {
+		s := segments[i]
+		expired := retention.MaxSegments > 0 && len(segments)-i > retention.MaxSegments
+		expired = expired || retention.MaxBytes > 0 && total > retention.MaxBytes
+
+		if !expired && retention.MaxAge > 0 {
+			timeIndex, err := loadSegmentTimeIndex(s, maxEntrySize)
+			if err != nil {
+				return deleted, err
+			}
+
+			last, ok := timeIndex.Last()
+			expired = ok && now.Sub(time.Unix(0, last.Timestamp)) > retention.MaxAge
+		}
+
+		if !expired {
+			break
+		}
+
+		log.Info("Retention deleting segment: ", s.Path, " base offset: ", s.Index.BaseOffset, " size: ", sizes[i])
+		err = deleteSegment(s.Path)
+		if err != nil {
+			return deleted, err
+		}
+
+		total -= sizes[i]
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+//deleteSegment removes every generation of the wal segment followed by their index files and quarantined tails.
+// Segments go first, the newest generation last, so that neither an older generation nor a segment without its index
+// ever shows up in the listing.
+func deleteSegment(segmentPath string) error {
+	dir := filepath.Dir(segmentPath)
+	stem := walSegmentStem(filepath.Base(segmentPath))
+
+	generations, err := filepath.Glob(filepath.Join(dir, stem+".*.wal"))
+	if err != nil {
+		return err
+	}
+
+	//The original sorts after its generations but is the oldest of them.
+	for _, p := range append([]string{filepath.Join(dir, stem+".wal")}, generations...) {
+		err = os.Remove(p)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	companions, err := filepath.Glob(filepath.Join(dir, stem+".*"))
+	if err != nil {
+		return err
+	}
+
+	for _, c := range companions {
+		err = os.Remove(c)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
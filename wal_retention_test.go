@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRetentionDeletesOldestSealedSegments(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	defaults := testTopicDefaults()
+	defaults.MaxSegmentSize = 40
+	topics, err := NewWalTopicManager(dir, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer topics.Close()
+
+	retentionSegments := 2
+	twr, err := topics.CreateTopic(WalTopicConfig{Name: "Test", PartitionCount: 1, RetentionSegments: &retentionSegments})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 10
+	for i := 0; i < count; i++ {
+		err := <-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte(fmt.Sprint(i))})
+		if err != nil {
+			t.Error("Failed to write record: ", err)
+			return
+		}
+	}
+
+	partitionDir := dir.Add("Test").AddUint32(0)
+	before, _ := ListWalSegments(partitionDir.String())
+	if len(before) <= retentionSegments {
+		t.Error("Expected the partition to roll over several segments but found: ", before)
+		return
+	}
+
+	topics.EnforceRetention()
+
+	after, _ := ListWalSegments(partitionDir.String())
+	if len(after) != retentionSegments || after[len(after)-1] != before[len(before)-1] {
+		t.Error("Expected the newest segments to be kept but found: ", after, " out of: ", before)
+		return
+	}
+
+	if _, err := os.Stat(SegmentIndexPath(partitionDir.Add(before[0]).String())); !os.IsNotExist(err) {
+		t.Error("Expected the index of a deleted segment to be gone: ", err)
+		return
+	}
+
+	reader := twr.NewReader()
+	start, err := reader.StartOffset(0)
+	if err != nil || start == 0 {
+		t.Error("Expected the start offset to move but found: ", start, " ", err)
+		return
+	}
+
+	_, err = reader.Partition(0, start-1)
+	if we, ok := err.(WalError); !ok || we.Code() != ErrOffsetOutOfRange {
+		t.Error("Expected offset out of range but found: ", err)
+		return
+	}
+
+	cursor, err := reader.Partition(0, start)
+	if err != nil {
+		t.Error("Failed to open cursor at the start offset: ", err)
+		return
+	}
+	defer cursor.Close()
+
+	e, err := cursor.Next()
+	if err != nil || e.Offset != start || string(e.Record.Record.Value) != fmt.Sprint(start) {
+		t.Error("Unexpected first entry after retention: ", e, " ", err)
+		return
+	}
+
+	server := NewWalHTTPServer("localhost", 0, topics)
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/topics/Test/partitions/0/records?offset=0", nil))
+	if resp.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Error("Expected 416 but found: ", resp.Code, " ", resp.Body.String())
+		return
+	}
+
+	err = <-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte("late")})
+	if err != nil {
+		t.Error("Failed to write after retention: ", err)
+	}
+}
+
+func TestRetentionKeepsSequencesUnique(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	//Smaller than a record, every segment is full after one.
+	defaults := testTopicDefaults()
+	defaults.MaxSegmentSize = 30
+	topics, err := NewWalTopicManager(dir, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retentionSegments := 1
+	twr, err := topics.CreateTopic(WalTopicConfig{Name: "Test", PartitionCount: 1, RetentionSegments: &retentionSegments})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		<-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte(fmt.Sprint(i))})
+	}
+
+	//Reopened with a full newest segment, the partition starts an empty one and retention deletes every record.
+	for i := 0; i < 2; i++ {
+		topics.Close()
+		topics, err = NewWalTopicManager(dir, defaults)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		topics.EnforceRetention()
+	}
+	defer topics.Close()
+
+	id, retChan := topics.Topic("Test").WriteWalRecordWithID(&WalRecord{Key: "k", Value: []byte("3")})
+	if err := <-retChan; err != nil || id.Sequence != 4 {
+		t.Error("Expected the sequence to continue after retention but found: ", id.Sequence, " ", err)
+	}
+}
+
+func TestRetentionByAgeAndBytes(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	twr, err := NewTopicWriter(dir, "Test", 1, 40, 0, NoFlush, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer twr.Close()
+
+	for i := 0; i < 6; i++ {
+		<-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte(fmt.Sprint(i))})
+	}
+
+	partitionDir := dir.Add("Test").AddUint32(0)
+	segments, _ := ListWalSegments(partitionDir.String())
+
+	deleted, err := EnforcePartitionRetention(partitionDir, WalRetention{MaxAge: time.Hour}, 0, time.Now())
+	if err != nil || deleted != 0 {
+		t.Error("Expected fresh segments to be kept but found: ", deleted, " ", err)
+		return
+	}
+
+	deleted, err = EnforcePartitionRetention(partitionDir, WalRetention{MaxBytes: 1 << 20}, 0, time.Now())
+	if err != nil || deleted != 0 {
+		t.Error("Expected segments under the byte limit to be kept but found: ", deleted, " ", err)
+		return
+	}
+
+	deleted, err = EnforcePartitionRetention(partitionDir, WalRetention{MaxBytes: 1}, 0, time.Now())
+	if err != nil || deleted != len(segments)-1 {
+		t.Error("Expected every sealed segment to go but found: ", deleted, " ", err)
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		<-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte(fmt.Sprint(i))})
+	}
+
+	deleted, err = EnforcePartitionRetention(partitionDir, WalRetention{MaxAge: time.Hour}, 0, time.Now().Add(2*time.Hour))
+	if err != nil || deleted == 0 {
+		t.Error("Expected old segments to go but found: ", deleted, " ", err)
+		return
+	}
+
+	segments, _ = ListWalSegments(partitionDir.String())
+	if len(segments) != 1 {
+		t.Error("Expected only the active segment to be left but found: ", segments)
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//WalIndexInterval number of entries between two consecutive index entries.
+const WalIndexInterval = 64
+
+const (
+	walIndexHeaderSize = 8
+	walIndexEntrySize  = 16
+)
+
+//WalIndexEntry maps the logical offset of an entry to its byte position in the segment.
+type WalIndexEntry struct {
+	Offset   int64
+	Position int64
+}
+
+//WalSegmentIndex sparse index of a segment. The file starts with the base offset of the segment
+// followed by an entry for the first record and every WalIndexInterval records after it.
+// Compacted segments also have an entry for every record following offsets compaction removed and one
+// positioned at the end of the segment holding its end offset, offsets are only counted between entries.
+type WalSegmentIndex struct {
+	BaseOffset int64
+	Entries    []WalIndexEntry
+}
+
+//SegmentIndexPath returns the path of the index belonging to the wal segment.
+func SegmentIndexPath(segmentPath string) string {
+	return strings.TrimSuffix(segmentPath, filepath.Ext(segmentPath)) + ".index"
+}
+
+//Lookup returns the closest index entry at or before offset.
+func (idx *WalSegmentIndex) Lookup(offset int64) WalIndexEntry {
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return idx.Entries[i].Offset > offset
+	})
+
+	if i == 0 {
+		return WalIndexEntry{Offset: idx.BaseOffset, Position: 0}
+	}
+
+	return idx.Entries[i-1]
+}
+
+//Last returns the last index entry or the segment start when there is none.
+func (idx *WalSegmentIndex) Last() WalIndexEntry {
+	if len(idx.Entries) == 0 {
+		return WalIndexEntry{Offset: idx.BaseOffset, Position: 0}
+	}
+
+	return idx.Entries[len(idx.Entries)-1]
+}
+
+//Bytes encodes the index the way it is stored on disk.
+func (idx *WalSegmentIndex) Bytes() []byte {
+	ret := make([]byte, walIndexHeaderSize+walIndexEntrySize*len(idx.Entries))
+	binary.LittleEndian.PutUint64(ret, uint64(idx.BaseOffset))
+
+	for i, e := range idx.Entries {
+		b := ret[walIndexHeaderSize+i*walIndexEntrySize:]
+		binary.LittleEndian.PutUint64(b, uint64(e.Offset))
+		binary.LittleEndian.PutUint64(b[8:], uint64(e.Position))
+	}
+
+	return ret
+}
+
+//ReadSegmentIndex reads the index of the wal segment. A partially written trailing entry is ignored.
+func ReadSegmentIndex(segmentPath string) (*WalSegmentIndex, error) {
+	b, err := ioutil.ReadFile(SegmentIndexPath(segmentPath))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < walIndexHeaderSize {
+		return nil, NewWalError(ErrCorruptedIndex, "Index header is incomplete: "+SegmentIndexPath(segmentPath))
+	}
+
+	ret := &WalSegmentIndex{
+		BaseOffset: int64(binary.LittleEndian.Uint64(b)),
+	}
+
+	for b = b[walIndexHeaderSize:]; len(b) >= walIndexEntrySize; b = b[walIndexEntrySize:] {
+		ret.Entries = append(ret.Entries, WalIndexEntry{
+			Offset:   int64(binary.LittleEndian.Uint64(b)),
+			Position: int64(binary.LittleEndian.Uint64(b[8:])),
+		})
+	}
+
+	return ret, nil
+}
+
+//ReadSegmentBase reads only the base offset from the index of the wal segment.
+func ReadSegmentBase(segmentPath string) (int64, error) {
+	f, err := os.Open(SegmentIndexPath(segmentPath))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	b := make([]byte, walIndexHeaderSize)
+	_, err = io.ReadFull(f, b)
+	if err != nil {
+		return 0, NewWalError(ErrCorruptedIndex, "Index header is incomplete: "+SegmentIndexPath(segmentPath))
+	}
+
+	return int64(binary.LittleEndian.Uint64(b)), nil
+}
+
+//WriteSegmentIndex replaces the index of the wal segment atomically.
+func WriteSegmentIndex(segmentPath string, idx *WalSegmentIndex) error {
+	indexPath := SegmentIndexPath(segmentPath)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(indexPath), filepath.Base(indexPath)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(idx.Bytes())
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), indexPath)
+}
+
+//BuildSegmentIndex scans the wal segment and returns its index along with the offset following its last valid entry.
+// A segment that does not exist yet gets an empty index.
+func BuildSegmentIndex(segmentPath string, baseOffset int64, maxEntrySize int64) (*WalSegmentIndex, int64, error) {
+	log.Debug("Building index of segment: ", segmentPath, " base offset: ", baseOffset)
+	idx := &WalSegmentIndex{
+		BaseOffset: baseOffset,
+	}
+
+	start := WalIndexEntry{Offset: baseOffset, Position: 0}
+	end, err := ScanSegment(segmentPath, nil, start, maxEntrySize, func(offset int64, position int64, wr *WalExRecord, crcOk bool) {
+		if (offset-baseOffset)%WalIndexInterval == 0 {
+			idx.Entries = append(idx.Entries, WalIndexEntry{Offset: offset, Position: position})
+		}
+	})
+
+	if err != nil {
+		return nil, baseOffset, err
+	}
+
+	return idx, end, nil
+}
+
+//SegmentEndOffset returns the offset following the last valid entry of the segment, scanning from its last index entry.
+func SegmentEndOffset(segmentPath string, idx *WalSegmentIndex, maxEntrySize int64) (int64, error) {
+	return ScanSegment(segmentPath, idx, idx.Last(), maxEntrySize, func(int64, int64, *WalExRecord, bool) {})
+}
+
+//ScanSegment hands every valid entry of the segment from the start entry onwards to fn and returns the offset following the last one.
+// Entries failing the crc check are handed over too, with crcOk false. A segment that does not exist yet ends at the start offset.
+// Offsets are taken from the entries of idx where compaction left gaps, idx may be nil while building it.
+func ScanSegment(segmentPath string, idx *WalSegmentIndex, start WalIndexEntry, maxEntrySize int64, fn func(offset int64, position int64, wr *WalExRecord, crcOk bool)) (int64, error) {
+	f, err := os.Open(segmentPath)
+	if os.IsNotExist(err) {
+		return start.Offset, nil
+	} else if err != nil {
+		return start.Offset, err
+	}
+	defer f.Close()
+
+	reader := &WalPartitionReader{
+		File:         f,
+		Reader:       bufio.NewReader(f),
+		MaxEntrySize: maxEntrySize,
+	}
+
+	err = reader.SeekTo(start.Position)
+	if err != nil {
+		return start.Offset, err
+	}
+
+	var entries []WalIndexEntry
+	if idx != nil {
+		entries = idx.Entries
+	}
+
+	offset := start.Offset
+	for {
+		position := reader.CurrentOffset
+		entries, offset = skipIndexGap(entries, position, offset)
+
+		wr, _, err := reader.ReadNextEntry()
+		if err != nil && err != ErrWrongChecksum {
+			return offset, nil
+		}
+
+		fn(offset, position, wr, err == nil)
+		offset++
+	}
+}
+
+//skipIndexGap drops the index entries up to position and returns the offset of the entry at position,
+// which is past the counted offset where compaction removed entries.
+func skipIndexGap(entries []WalIndexEntry, position int64, offset int64) ([]WalIndexEntry, int64) {
+	for len(entries) > 0 && entries[0].Position <= position {
+		if entries[0].Position == position && entries[0].Offset > offset {
+			offset = entries[0].Offset
+		}
+
+		entries = entries[1:]
+	}
+
+	return entries, offset
+}
+
+//walSegment a segment of a partition along with its index.
+type walSegment struct {
+	Name  string
+	Path  string
+	Index *WalSegmentIndex
+}
+
+//LoadPartitionSegments returns the segments of the partition in order along with their indexes.
+// Missing indexes are rebuilt from the segment contents, continuing from the end of the previous segment.
+func LoadPartitionSegments(partitionDir Path, maxEntrySize int64) ([]*walSegment, error) {
+	return loadPartitionSegments(partitionDir, maxEntrySize, true)
+}
+
+//loadPartitionSegments implements LoadPartitionSegments, rebuilt indexes are only written next to their segments when persist is set.
+func loadPartitionSegments(partitionDir Path, maxEntrySize int64, persist bool) ([]*walSegment, error) {
+	names, err := ListWalSegments(partitionDir.String())
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*walSegment, 0, len(names))
+	for _, name := range names {
+		segmentPath := partitionDir.Add(name).String()
+
+		idx, err := ReadSegmentIndex(segmentPath)
+		if os.IsNotExist(err) {
+			if _, serr := os.Stat(segmentPath); os.IsNotExist(serr) {
+				//Deleted by retention or replaced by compaction since listed.
+				continue
+			}
+
+			var base int64
+			if len(ret) > 0 {
+				prev := ret[len(ret)-1]
+				base, err = SegmentEndOffset(prev.Path, prev.Index, maxEntrySize)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			idx, _, err = BuildSegmentIndex(segmentPath, base, maxEntrySize)
+			if err != nil {
+				return nil, err
+			}
+
+			if persist {
+				err = WriteSegmentIndex(segmentPath, idx)
+				if err != nil {
+					log.Warn("Failed to persist rebuilt index of: ", segmentPath, " ", err)
+				}
+			}
+		} else if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, &walSegment{
+			Name:  name,
+			Path:  segmentPath,
+			Index: idx,
+		})
+	}
+
+	return ret, nil
+}
+
+//PartitionStartOffset returns the offset of the oldest entry still kept by the partition, the base offset of its first segment.
+func PartitionStartOffset(partitionDir Path, maxEntrySize int64) (int64, error) {
+	segments, err := LoadPartitionSegments(partitionDir, maxEntrySize)
+	if err != nil || len(segments) == 0 {
+		return 0, err
+	}
+
+	return segments[0].Index.BaseOffset, nil
+}
+
+//PartitionEndOffset returns the offset the next entry appended to the partition will get.
+func PartitionEndOffset(partitionDir Path, maxEntrySize int64) (int64, error) {
+	segments, err := LoadPartitionSegments(partitionDir, maxEntrySize)
+	if err != nil || len(segments) == 0 {
+		return 0, err
+	}
+
+	last := segments[len(segments)-1]
+	return SegmentEndOffset(last.Path, last.Index, maxEntrySize)
+}
+
+//partitionSequenceFile holds the last sequence of a partition as of when its newest segment got sealed.
+const partitionSequenceFile = "last.sequence"
+
+//PartitionLastSequence returns the sequence of the last record of the partition passing the crc check, 0 if there is none.
+// Segments are searched from the newest one backwards, skipping empty ones. The sequence stored by
+// WritePartitionSequence wins when higher, retention and compaction may have removed the records holding it.
+func PartitionLastSequence(partitionDir Path, maxEntrySize int64) (uint64, error) {
+	stored, err := ReadPartitionSequence(partitionDir)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := scanLastRecord(partitionDir, maxEntrySize)
+	if err != nil || id == nil || id.Sequence < stored {
+		return stored, err
+	}
+
+	return id.Sequence, nil
+}
+
+//PartitionLastTimestamp returns the timestamp of the last record of the partition passing the crc check, 0 if there is none.
+func PartitionLastTimestamp(partitionDir Path, maxEntrySize int64) (int64, error) {
+	id, err := scanLastRecord(partitionDir, maxEntrySize)
+	if err != nil || id == nil {
+		return 0, err
+	}
+
+	return id.Timestamp, nil
+}
+
+//ReadPartitionSequence reads the sequence stored by WritePartitionSequence, 0 if none was.
+func ReadPartitionSequence(partitionDir Path) (uint64, error) {
+	b, err := ioutil.ReadFile(partitionDir.Add(partitionSequenceFile).String())
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	if len(b) < 8 {
+		return 0, NewWalError(ErrCorruptedIndex, "Sequence is incomplete: "+partitionDir.Add(partitionSequenceFile).String())
+	}
+
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+//WritePartitionSequence stores the last sequence assigned in the partition atomically.
+func WritePartitionSequence(partitionDir Path, sequence uint64) error {
+	sequencePath := partitionDir.Add(partitionSequenceFile).String()
+
+	tmp, err := ioutil.TempFile(partitionDir.String(), partitionSequenceFile+".tmp")
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, sequence)
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), sequencePath)
+}
+
+//scanLastRecord returns the id of the record with the highest sequence in the newest index interval holding one passing
+// the crc check, nil if there is none.
+func scanLastRecord(partitionDir Path, maxEntrySize int64) (*WalRecordID, error) {
+	segments, err := LoadPartitionSegments(partitionDir, maxEntrySize)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(segments) - 1; i >= 0; i-- {
+		s := segments[i]
+		entries := s.Index.Entries
+		for j := len(entries) - 1; j >= 0; j-- {
+			var last *WalRecordID
+			_, err := ScanSegment(s.Path, s.Index, entries[j], maxEntrySize, func(offset int64, position int64, wr *WalExRecord, crcOk bool) {
+				if crcOk && (last == nil || wr.ID.Sequence >= last.Sequence) {
+					last = wr.ID
+				}
+			})
+
+			if err != nil {
+				return nil, err
+			}
+
+			if last != nil {
+				return last, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
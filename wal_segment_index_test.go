@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSegmentIndexMaintainedAndRebuilt(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	os.MkdirAll(dir.String(), os.ModePerm)
+	segment := dir.Add("1.wal").String()
+
+	writer, err := NewWalPartitionWriter(segment, 100, 1024*1024, 0, NoFlush)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 3*WalIndexInterval + 5
+	for i := 0; i < count; i++ {
+		b, err := NewWalExRecord(&WalRecord{Key: "k", Value: []byte(fmt.Sprint(i))}, uint64(i), time.Now().UnixNano()).Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = writer.Write(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writer.Close()
+
+	idx, err := ReadSegmentIndex(segment)
+	if err != nil {
+		t.Error("Failed to read index: ", err)
+		return
+	}
+
+	if idx.BaseOffset != 100 || len(idx.Entries) != 4 {
+		t.Error("Unexpected index: ", idx.BaseOffset, " ", idx.Entries)
+		return
+	}
+
+	e := idx.Lookup(100 + 2*WalIndexInterval + 3)
+	if e.Offset != 100+2*WalIndexInterval {
+		t.Error("Unexpected lookup result: ", e)
+		return
+	}
+
+	os.Remove(SegmentIndexPath(segment))
+	rebuilt, end, err := BuildSegmentIndex(segment, 100, 0)
+	if err != nil || end != int64(100+count) {
+		t.Error("Failed to rebuild index: ", end, " ", err)
+		return
+	}
+
+	for i := range idx.Entries {
+		if rebuilt.Entries[i] != idx.Entries[i] {
+			t.Error("Rebuilt index differs at: ", i, " ", rebuilt.Entries[i], " ", idx.Entries[i])
+			return
+		}
+	}
+
+	err = WriteSegmentIndex(segment, rebuilt)
+	if err != nil {
+		t.Error("Failed to write index: ", err)
+		return
+	}
+
+	//Reopening keeps the base offset and continues the numbering.
+	writer, err = NewWalPartitionWriter(segment, 0, 1024*1024, 0, NoFlush)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	if writer.BaseOffset != 100 || writer.NextOffset != int64(100+count) {
+		t.Error("Unexpected offsets after reopening: ", writer.BaseOffset, " ", writer.NextOffset)
+	}
+}
+
+func TestIndexWriteFailureKeepsData(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	os.MkdirAll(dir.String(), os.ModePerm)
+	segment := dir.Add("1.wal").String()
+
+	writer, err := NewWalPartitionWriter(segment, 0, 1024*1024, 0, NoFlush)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadSegmentTimeIndex(segment); err != nil {
+		t.Error("Expected the time index to exist along with the segment: ", err)
+	}
+
+	//Index appends fail from now on.
+	writer.IndexFile.Close()
+	writer.TimeIndexFile.Close()
+
+	count := 2*WalIndexInterval + 1
+	for i := 0; i < count; i++ {
+		b, err := NewWalExRecord(&WalRecord{Key: "k", Value: []byte(fmt.Sprint(i))}, uint64(i), time.Now().UnixNano()).Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = writer.Write(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = writer.Flush()
+		if err != nil {
+			t.Error("Expected the flush to succeed despite the index: ", err)
+			return
+		}
+	}
+
+	writer.Close()
+
+	idx, err := ReadSegmentIndex(segment)
+	if err != nil || len(idx.Entries) != 3 {
+		t.Error("Expected the index to be rebuilt on close: ", idx, " ", err)
+		return
+	}
+
+	timeIndex, err := ReadSegmentTimeIndex(segment)
+	if last, ok := timeIndex.Last(); err != nil || !ok || last.Offset != int64(count) {
+		t.Error("Expected the time index to be rebuilt on close: ", timeIndex, " ", err)
+	}
+}
+
+func TestCursorSeeksWithIndex(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	twr, err := NewTopicWriter(dir, "Test", 1, 4096, 0, NoFlush, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer twr.Close()
+
+	count := 500
+	for i := 0; i < count; i++ {
+		err := <-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte(fmt.Sprint(i))})
+		if err != nil {
+			t.Error("Failed to write record: ", err)
+			return
+		}
+	}
+
+	for _, offset := range []int64{0, 63, 64, 65, 250, 499} {
+		cursor, err := twr.NewReader().Partition(0, offset)
+		if err != nil {
+			t.Error("Failed to open cursor: ", err)
+			return
+		}
+
+		e, err := cursor.Next()
+		cursor.Close()
+		if err != nil || e.Offset != offset || string(e.Record.Record.Value) != fmt.Sprint(offset) {
+			t.Error("Unexpected entry at offset: ", offset, " ", e, " ", err)
+			return
+		}
+	}
+
+	end, err := twr.NewReader().EndOffset(0)
+	if err != nil || end != int64(count) {
+		t.Error("Unexpected end offset: ", end, " ", err)
+	}
+}
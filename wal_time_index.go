@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//WalTimeIndexEntry every entry of the segment before Offset has a timestamp at or below Timestamp.
+// The first entry of a time index holds the timestamp of the first record of the segment.
+type WalTimeIndexEntry struct {
+	Timestamp int64
+	Offset    int64
+}
+
+//WalSegmentTimeIndex sparse time index of a segment, written every WalIndexInterval records and when the segment is closed.
+type WalSegmentTimeIndex struct {
+	Entries []WalTimeIndexEntry
+}
+
+//SegmentTimeIndexPath returns the path of the time index belonging to the wal segment.
+func SegmentTimeIndexPath(segmentPath string) string {
+	return strings.TrimSuffix(segmentPath, filepath.Ext(segmentPath)) + ".timeindex"
+}
+
+//Lookup returns the offset from which to scan for the first record at or after timestamp,
+// every record before it is known to be older.
+func (idx *WalSegmentTimeIndex) Lookup(baseOffset int64, timestamp int64) int64 {
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return idx.Entries[i].Timestamp >= timestamp
+	})
+
+	if i == 0 {
+		return baseOffset
+	}
+
+	return idx.Entries[i-1].Offset
+}
+
+//Last returns the last entry of the time index, false when it is empty.
+func (idx *WalSegmentTimeIndex) Last() (WalTimeIndexEntry, bool) {
+	if len(idx.Entries) == 0 {
+		return WalTimeIndexEntry{}, false
+	}
+
+	return idx.Entries[len(idx.Entries)-1], true
+}
+
+//Bytes encodes the time index the way it is stored on disk.
+func (idx *WalSegmentTimeIndex) Bytes() []byte {
+	ret := make([]byte, walIndexEntrySize*len(idx.Entries))
+	for i, e := range idx.Entries {
+		b := ret[i*walIndexEntrySize:]
+		binary.LittleEndian.PutUint64(b, uint64(e.Timestamp))
+		binary.LittleEndian.PutUint64(b[8:], uint64(e.Offset))
+	}
+
+	return ret
+}
+
+//ReadSegmentTimeIndex reads the time index of the wal segment. A partially written trailing entry is ignored.
+func ReadSegmentTimeIndex(segmentPath string) (*WalSegmentTimeIndex, error) {
+	b, err := ioutil.ReadFile(SegmentTimeIndexPath(segmentPath))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &WalSegmentTimeIndex{}
+	for ; len(b) >= walIndexEntrySize; b = b[walIndexEntrySize:] {
+		ret.Entries = append(ret.Entries, WalTimeIndexEntry{
+			Timestamp: int64(binary.LittleEndian.Uint64(b)),
+			Offset:    int64(binary.LittleEndian.Uint64(b[8:])),
+		})
+	}
+
+	return ret, nil
+}
+
+//WriteSegmentTimeIndex replaces the time index of the wal segment atomically.
+func WriteSegmentTimeIndex(segmentPath string, idx *WalSegmentTimeIndex) error {
+	indexPath := SegmentTimeIndexPath(segmentPath)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(indexPath), filepath.Base(indexPath)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(idx.Bytes())
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), indexPath)
+}
+
+//BuildSegmentTimeIndex scans the wal segment using its offset index and returns its time index, closed by an entry for
+// the end of the segment, along with the highest timestamp found. A segment compaction emptied gets only the closing entry.
+func BuildSegmentTimeIndex(segmentPath string, offsetIndex *WalSegmentIndex, maxEntrySize int64) (*WalSegmentTimeIndex, int64, error) {
+	log.Debug("Building time index of segment: ", segmentPath, " base offset: ", offsetIndex.BaseOffset)
+	idx := &WalSegmentTimeIndex{}
+
+	var maxTimestamp int64
+	var count int64
+	start := WalIndexEntry{Offset: offsetIndex.BaseOffset, Position: 0}
+	end, err := ScanSegment(segmentPath, offsetIndex, start, maxEntrySize, func(offset int64, position int64, wr *WalExRecord, crcOk bool) {
+		if count == 0 {
+			idx.Entries = append(idx.Entries, WalTimeIndexEntry{Timestamp: wr.ID.Timestamp, Offset: offset})
+		} else if count%WalIndexInterval == 0 {
+			idx.Entries = append(idx.Entries, WalTimeIndexEntry{Timestamp: maxTimestamp, Offset: offset})
+		}
+
+		if count == 0 || wr.ID.Timestamp > maxTimestamp {
+			maxTimestamp = wr.ID.Timestamp
+		}
+
+		count++
+	})
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	last, ok := idx.Last()
+	if ok && last.Offset < end || !ok && end > offsetIndex.BaseOffset {
+		idx.Entries = append(idx.Entries, WalTimeIndexEntry{Timestamp: maxTimestamp, Offset: end})
+	}
+
+	return idx, maxTimestamp, nil
+}
+
+//loadSegmentTimeIndex reads the time index of the segment, rebuilding it when missing.
+func loadSegmentTimeIndex(segment *walSegment, maxEntrySize int64) (*WalSegmentTimeIndex, error) {
+	idx, err := ReadSegmentTimeIndex(segment.Path)
+	if !os.IsNotExist(err) {
+		return idx, err
+	}
+
+	idx, _, err = BuildSegmentTimeIndex(segment.Path, segment.Index, maxEntrySize)
+	if err != nil {
+		return nil, err
+	}
+
+	err = WriteSegmentTimeIndex(segment.Path, idx)
+	if err != nil {
+		log.Warn("Failed to persist rebuilt time index of: ", segment.Path, " ", err)
+	}
+
+	return idx, nil
+}
+
+//walExRecordTimestamp returns the timestamp of the encoded WalExRecord.
+func walExRecordTimestamp(p []byte) int64 {
+	if len(p) < 8 {
+		return 0
+	}
+
+	return int64(binary.LittleEndian.Uint64(p))
+}
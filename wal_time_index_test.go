@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCursorSeeksToTime(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	twr, err := NewTopicWriter(dir, "Test", 1, 2048, 0, NoFlush, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer twr.Close()
+
+	count := 300
+	timestamps := make([]int64, count)
+	for i := 0; i < count; i++ {
+		id, retChan := twr.WriteWalRecordWithID(&WalRecord{Key: "k", Value: []byte(fmt.Sprint(i))})
+		err := <-retChan
+		if err != nil {
+			t.Error("Failed to write record: ", err)
+			return
+		}
+
+		timestamps[i] = id.Timestamp
+	}
+
+	partitionDir := dir.Add("Test").AddUint32(0)
+	segments, err := LoadPartitionSegments(partitionDir, 0)
+	if err != nil || len(segments) < 3 {
+		t.Error("Expected the partition to roll over several segments but found: ", len(segments), " ", err)
+		return
+	}
+
+	//A sealed segment ends with an entry covering all of it.
+	timeIndex, err := ReadSegmentTimeIndex(segments[0].Path)
+	if err != nil {
+		t.Error("Failed to read time index: ", err)
+		return
+	}
+
+	last, ok := timeIndex.Last()
+	if !ok || last.Offset != segments[1].Index.BaseOffset || timeIndex.Entries[0].Timestamp != timestamps[0] {
+		t.Error("Unexpected time index of the first segment: ", timeIndex.Entries)
+		return
+	}
+
+	reader := twr.NewReader()
+	for _, target := range []int{0, 1, 150, 299} {
+		expected := target
+		for expected > 0 && timestamps[expected-1] >= timestamps[target] {
+			expected--
+		}
+
+		offset, err := reader.OffsetForTime(0, timestamps[target])
+		if err != nil || offset != int64(expected) {
+			t.Error("Expected offset ", expected, " for the timestamp of ", target, " but found: ", offset, " ", err)
+			return
+		}
+	}
+
+	offset, err := reader.OffsetForTime(0, timestamps[count-1]+1)
+	if err != nil || offset != int64(count) {
+		t.Error("Expected the end offset for a future timestamp but found: ", offset, " ", err)
+		return
+	}
+
+	//Missing time indexes get rebuilt.
+	for _, s := range segments {
+		os.Remove(SegmentTimeIndexPath(s.Path))
+	}
+
+	offset, err = reader.OffsetForTime(0, timestamps[150])
+	if err != nil || offset > 150 || timestamps[offset] < timestamps[150] {
+		t.Error("Unexpected offset after rebuilding the time indexes: ", offset, " ", err)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"io"
+	"time"
 )
 
 //WalSyncType sets the type of commit.
@@ -17,11 +18,58 @@ const (
 	NoFlush WalSyncType = "WaitForBatchOrTimeout"
 )
 
+//Valid checks the sync type is one of the known values.
+func (t WalSyncType) Valid() bool {
+	return t == FlushOnCommit || t == NoFlush
+}
+
 //WalTopicConfig serializes the topic config to file.
+// Retention settings are per partition, segments breaking any of them get deleted. Nil keeps the data forever.
+// Compacted topics keep only the newest record of every key in their sealed segments, see CompactPartition.
+// Partitioner names the built-in partitioner of records written without one, see NewPartitioner.
 type WalTopicConfig struct {
-	Name           string       `json:"name"`
-	PartitionCount uint32       `json:"partitionCount"`
-	WalSyncType    *WalSyncType `json:"walSyncType"`
+	Name                     string       `json:"name"`
+	PartitionCount           uint32       `json:"partitionCount"`
+	WalSyncType              *WalSyncType `json:"walSyncType"`
+	MaxRecordSize            *int64       `json:"maxRecordSize"`
+	RetentionMillis          *int64       `json:"retentionMillis,omitempty"`
+	RetentionBytes           *int64       `json:"retentionBytes,omitempty"`
+	RetentionSegments        *int         `json:"retentionSegments,omitempty"`
+	Compact                  *bool        `json:"compact,omitempty"`
+	TombstoneRetentionMillis *int64       `json:"tombstoneRetentionMillis,omitempty"`
+	Partitioner              string       `json:"partitioner,omitempty"`
+}
+
+//Compacted returns true if the topic has compaction turned on.
+func (tc *WalTopicConfig) Compacted() bool {
+	return tc.Compact != nil && *tc.Compact
+}
+
+//TombstoneRetention returns how long compaction keeps the tombstones of the topic.
+func (tc *WalTopicConfig) TombstoneRetention() time.Duration {
+	if tc.TombstoneRetentionMillis == nil {
+		return DefaultTombstoneRetention
+	}
+
+	return time.Duration(*tc.TombstoneRetentionMillis) * time.Millisecond
+}
+
+//Retention returns the retention settings of the topic.
+func (tc *WalTopicConfig) Retention() WalRetention {
+	ret := WalRetention{}
+	if tc.RetentionMillis != nil {
+		ret.MaxAge = time.Duration(*tc.RetentionMillis) * time.Millisecond
+	}
+
+	if tc.RetentionBytes != nil {
+		ret.MaxBytes = *tc.RetentionBytes
+	}
+
+	if tc.RetentionSegments != nil {
+		ret.MaxSegments = *tc.RetentionSegments
+	}
+
+	return ret
 }
 
 //WalTopicsConfig a collection of topic config.
@@ -30,7 +78,7 @@ type WalTopicsConfig []WalTopicConfig
 //ReadConfig from reader.
 func (wc *WalTopicsConfig) ReadConfig(r *io.Reader) error {
 	decoder := json.NewDecoder(*r)
-	return decoder.Decode(*wc)
+	return decoder.Decode(wc)
 }
 
 //WriteConfig to writer.
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//topicsManifest is the file in the data directory listing all topics.
+const topicsManifest = "topics.json"
+
+var topicNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,248}$`)
+
+//internalTopicPrefix marks the topics the server keeps for itself, they can not be created or deleted over the api.
+const internalTopicPrefix = "__"
+
+//WalTopicDefaults are the settings of topics that do not override them.
+// Quarantine keeps the corrupted tails dropped on startup in files next to their segments.
+// RetentionCheckInterval is how often retention and compaction run in the background, 0 disables both.
+// MaxBatchSize bounds the records a single request writes together, 0 disables it.
+type WalTopicDefaults struct {
+	MaxSegmentSize         int64
+	MaxRecordSize          int64
+	MaxBatchSize           int64
+	WalSyncType            WalSyncType
+	FlushTimeout           time.Duration
+	Quarantine             bool
+	RetentionCheckInterval time.Duration
+}
+
+//WalTopicManager keeps the registry of topics in a data directory along with their writers.
+type WalTopicManager struct {
+	mutex sync.RWMutex
+	Dir   Path
+
+	defaults WalTopicDefaults
+	configs  map[string]WalTopicConfig
+	writers  map[string]*WalTopicWriter
+	groups   *WalConsumerGroups
+
+	janitorDone chan struct{}
+	janitorWg   sync.WaitGroup
+	closeOnce   sync.Once
+}
+
+//NewWalTopicManager loads the topics manifest from dir and reopens a writer for every topic in it.
+func NewWalTopicManager(dir Path, defaults WalTopicDefaults) (*WalTopicManager, error) {
+	log.Debug("Loading topics from: ", dir)
+	err := os.MkdirAll(dir.String(), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &WalTopicManager{
+		Dir:         dir,
+		defaults:    defaults,
+		configs:     make(map[string]WalTopicConfig),
+		writers:     make(map[string]*WalTopicWriter),
+		janitorDone: make(chan struct{}),
+	}
+
+	configs, err := ret.readManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tc := range configs {
+		log.Info("Opening topic: ", tc.Name, " partitions: ", tc.PartitionCount)
+		twr, err := ret.openTopic(tc)
+		if err != nil {
+			ret.Close()
+			return nil, err
+		}
+
+		ret.configs[tc.Name] = tc
+		ret.writers[tc.Name] = twr
+	}
+
+	err = ret.openConsumerGroups()
+	if err != nil {
+		ret.Close()
+		return nil, err
+	}
+
+	if defaults.RetentionCheckInterval > 0 {
+		ret.janitorWg.Add(1)
+		go ret.janitor(defaults.RetentionCheckInterval)
+	}
+
+	return ret, nil
+}
+
+//Topic returns the writer of the topic or nil if there is no such topic.
+func (m *WalTopicManager) Topic(name string) *WalTopicWriter {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.writers[name]
+}
+
+//TopicConfig returns the config of the topic.
+func (m *WalTopicManager) TopicConfig(name string) (WalTopicConfig, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	tc, ok := m.configs[name]
+	return tc, ok
+}
+
+//Topics returns the config of every topic sorted by name, internal topics left out.
+func (m *WalTopicManager) Topics() WalTopicsConfig {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	ret := make(WalTopicsConfig, 0, len(m.configs))
+	for _, tc := range m.sortedConfigs() {
+		if !strings.HasPrefix(tc.Name, internalTopicPrefix) {
+			ret = append(ret, tc)
+		}
+	}
+
+	return ret
+}
+
+//Groups returns the offsets committed by consumer groups.
+func (m *WalTopicManager) Groups() *WalConsumerGroups {
+	return m.groups
+}
+
+//Defaults returns the settings of topics that do not override them.
+func (m *WalTopicManager) Defaults() WalTopicDefaults {
+	return m.defaults
+}
+
+//CreateTopic registers a new topic, persists the manifest and opens its writer.
+func (m *WalTopicManager) CreateTopic(tc WalTopicConfig) (*WalTopicWriter, error) {
+	if strings.HasPrefix(tc.Name, internalTopicPrefix) {
+		return nil, NewWalError(ErrInvalidTopicConfig, fmt.Sprint("Topic names starting with ", internalTopicPrefix, " are reserved: ", tc.Name))
+	}
+
+	return m.createTopic(tc)
+}
+
+func (m *WalTopicManager) createTopic(tc WalTopicConfig) (*WalTopicWriter, error) {
+	if !topicNamePattern.MatchString(tc.Name) || tc.Name == topicsManifest {
+		return nil, NewWalError(ErrInvalidTopicConfig, fmt.Sprint("Invalid topic name: ", tc.Name))
+	}
+
+	if tc.PartitionCount == 0 {
+		return nil, NewWalError(ErrInvalidTopicConfig, "Partition count must be greater than 0.")
+	}
+
+	if tc.MaxRecordSize == nil {
+		maxRecordSize := m.defaults.MaxRecordSize
+		tc.MaxRecordSize = &maxRecordSize
+	} else if *tc.MaxRecordSize <= 0 {
+		return nil, NewWalError(ErrInvalidTopicConfig, fmt.Sprint("Invalid max record size: ", *tc.MaxRecordSize))
+	}
+
+	if tc.WalSyncType == nil {
+		walSyncType := m.defaults.WalSyncType
+		tc.WalSyncType = &walSyncType
+	} else if !tc.WalSyncType.Valid() {
+		return nil, NewWalError(ErrInvalidTopicConfig, fmt.Sprint("Invalid wal sync type: ", *tc.WalSyncType))
+	}
+
+	if tc.RetentionMillis != nil && *tc.RetentionMillis <= 0 {
+		return nil, NewWalError(ErrInvalidTopicConfig, fmt.Sprint("Invalid retention millis: ", *tc.RetentionMillis))
+	}
+
+	if tc.RetentionBytes != nil && *tc.RetentionBytes <= 0 {
+		return nil, NewWalError(ErrInvalidTopicConfig, fmt.Sprint("Invalid retention bytes: ", *tc.RetentionBytes))
+	}
+
+	if tc.RetentionSegments != nil && *tc.RetentionSegments <= 0 {
+		return nil, NewWalError(ErrInvalidTopicConfig, fmt.Sprint("Invalid retention segments: ", *tc.RetentionSegments))
+	}
+
+	if tc.TombstoneRetentionMillis != nil && *tc.TombstoneRetentionMillis < 0 {
+		return nil, NewWalError(ErrInvalidTopicConfig, fmt.Sprint("Invalid tombstone retention millis: ", *tc.TombstoneRetentionMillis))
+	}
+
+	if _, err := NewPartitioner(tc.Partitioner); err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.configs[tc.Name]; ok {
+		return nil, NewWalError(ErrTopicExists, fmt.Sprint("Topic already exists: ", tc.Name))
+	}
+
+	m.configs[tc.Name] = tc
+	err := m.writeManifest()
+	if err != nil {
+		delete(m.configs, tc.Name)
+		return nil, err
+	}
+
+	twr, err := m.openTopic(tc)
+	if err != nil {
+		delete(m.configs, tc.Name)
+		if rollbackErr := m.writeManifest(); rollbackErr != nil {
+			log.Warn("Failed to remove topic: ", tc.Name, " from the manifest ", rollbackErr)
+		}
+
+		return nil, err
+	}
+
+	log.Info("Created topic: ", tc.Name, " partitions: ", tc.PartitionCount)
+	m.writers[tc.Name] = twr
+	return twr, nil
+}
+
+//DeleteTopic closes the topic writer, removes the topic from the manifest and deletes its data along with the offsets
+// consumer groups committed for it.
+func (m *WalTopicManager) DeleteTopic(name string) error {
+	if strings.HasPrefix(name, internalTopicPrefix) {
+		return NewWalError(ErrInvalidTopicConfig, fmt.Sprint("Internal topics can not be deleted: ", name))
+	}
+
+	err := m.deleteTopic(name)
+	if err != nil {
+		return err
+	}
+
+	//Outside of the lock, rebalancing looks the topics up.
+	if m.groups != nil {
+		return m.groups.topicDeleted(name)
+	}
+
+	return nil
+}
+
+func (m *WalTopicManager) deleteTopic(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	tc, ok := m.configs[name]
+	if !ok {
+		return NewWalError(ErrTopicNotFound, fmt.Sprint("No such topic: ", name))
+	}
+
+	delete(m.configs, name)
+	err := m.writeManifest()
+	if err != nil {
+		m.configs[name] = tc
+		return err
+	}
+
+	twr := m.writers[name]
+	delete(m.writers, name)
+	if twr != nil {
+		twr.Close()
+	}
+
+	log.Info("Deleted topic: ", name)
+	return os.RemoveAll(m.Dir.Add(name).String())
+}
+
+//IncreasePartitions grows the topic to partitionCount partitions while it is being written. Topics created with
+// ConsistentPartitioner move the fewest keys. A non empty partitioner replaces the one of the topic only if that one is
+// RoundRobinPartitioner, any other change would move almost every key. Keys moving to another partition lose their
+// ordering with the records written before, and compaction only keeps their newest record per partition.
+func (m *WalTopicManager) IncreasePartitions(name string, partitionCount uint32, partitioner string) (WalTopicConfig, error) {
+	if strings.HasPrefix(name, internalTopicPrefix) {
+		return WalTopicConfig{}, NewWalError(ErrInvalidTopicConfig, fmt.Sprint("Internal topics can not be changed: ", name))
+	}
+
+	tc, err := m.increasePartitions(name, partitionCount, partitioner)
+	if err != nil {
+		return tc, err
+	}
+
+	//Outside of the lock, rebalancing looks the topics up.
+	if m.groups != nil {
+		m.groups.topicResized(name)
+	}
+
+	return tc, nil
+}
+
+func (m *WalTopicManager) increasePartitions(name string, partitionCount uint32, partitioner string) (WalTopicConfig, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	tc, ok := m.configs[name]
+	twr := m.writers[name]
+	if !ok || twr == nil {
+		return WalTopicConfig{}, NewWalError(ErrTopicNotFound, fmt.Sprint("No such topic: ", name))
+	}
+
+	if partitionCount <= tc.PartitionCount {
+		return WalTopicConfig{}, NewWalError(ErrInvalidTopicConfig, fmt.Sprint("Partition count can only grow from: ", tc.PartitionCount, " requested: ", partitionCount))
+	}
+
+	updated := tc
+	updated.PartitionCount = partitionCount
+	if partitioner != "" {
+		updated.Partitioner = partitioner
+	}
+
+	p, err := NewPartitioner(updated.Partitioner)
+	if err != nil {
+		return WalTopicConfig{}, err
+	}
+
+	current := tc.Partitioner
+	if current == "" {
+		current = Crc32Partitioner
+	}
+
+	if partitioner != "" && partitioner != current && current != RoundRobinPartitioner {
+		return WalTopicConfig{}, NewWalError(ErrInvalidTopicConfig, fmt.Sprint("Partitioner of topic: ", name, " can not change from: ", current, " to: ", partitioner, ", keys would move to other partitions"))
+	}
+
+	//Persisted first so that no record gets written to a partition a restart would not open.
+	m.configs[name] = updated
+	err = m.writeManifest()
+	if err != nil {
+		m.configs[name] = tc
+		return WalTopicConfig{}, err
+	}
+
+	err = twr.AddPartitions(partitionCount)
+	if err != nil {
+		m.configs[name] = tc
+		if rollbackErr := m.writeManifest(); rollbackErr != nil {
+			log.Warn("Failed to restore the config of topic: ", name, " ", rollbackErr)
+		}
+
+		return WalTopicConfig{}, err
+	}
+
+	if partitioner != "" {
+		twr.SetPartitioner(p)
+	}
+
+	log.Info("Increased partitions of topic: ", name, " from: ", tc.PartitionCount, " to: ", partitionCount)
+	return updated, nil
+}
+
+//EnforceRetention deletes the segments of every topic breaking its retention settings.
+func (m *WalTopicManager) EnforceRetention() {
+	configs, writers := m.snapshot()
+
+	for _, tc := range configs {
+		twr := writers[tc.Name]
+		retention := tc.Retention()
+		if twr == nil || !retention.Enabled() {
+			continue
+		}
+
+		var p uint32
+		for p = 0; p < tc.PartitionCount; p++ {
+			_, err := EnforcePartitionRetention(twr.Dir.AddUint32(p), retention, twr.MaxEntrySize(), time.Now())
+			if err != nil {
+				log.Warn("Failed to enforce retention of topic: ", tc.Name, " partition: ", p, " ", err)
+			}
+		}
+	}
+}
+
+//Compact compacts the sealed segments of every topic with compaction turned on.
+func (m *WalTopicManager) Compact() {
+	configs, writers := m.snapshot()
+
+	for _, tc := range configs {
+		twr := writers[tc.Name]
+		if twr == nil || !tc.Compacted() {
+			continue
+		}
+
+		var p uint32
+		for p = 0; p < tc.PartitionCount; p++ {
+			_, err := CompactPartition(twr.Dir.AddUint32(p), tc.TombstoneRetention(), twr.MaxEntrySize(), time.Now())
+			if err != nil {
+				log.Warn("Failed to compact topic: ", tc.Name, " partition: ", p, " ", err)
+			}
+		}
+	}
+}
+
+//snapshot returns the topic configs along with their writers, for background work not holding the lock.
+func (m *WalTopicManager) snapshot() (WalTopicsConfig, map[string]*WalTopicWriter) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	writers := make(map[string]*WalTopicWriter, len(m.writers))
+	for name, twr := range m.writers {
+		writers[name] = twr
+	}
+
+	return m.sortedConfigs(), writers
+}
+
+//janitor enforces retention and compacts every interval until the manager gets closed.
+func (m *WalTopicManager) janitor(interval time.Duration) {
+	defer m.janitorWg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.EnforceRetention()
+			m.Compact()
+		case <-m.janitorDone:
+			return
+		}
+	}
+}
+
+//Close stops the retention janitor and closes all topic writers.
+func (m *WalTopicManager) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.janitorDone)
+	})
+	m.janitorWg.Wait()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for name, twr := range m.writers {
+		log.Debug("Closing topic: ", name)
+		twr.Close()
+	}
+
+	m.writers = make(map[string]*WalTopicWriter)
+	return nil
+}
+
+//openConsumerGroups creates the internal offsets topic on first start and loads the committed offsets from it.
+func (m *WalTopicManager) openConsumerGroups() error {
+	twr := m.Topic(consumerOffsetsTopic)
+	if twr == nil {
+		compact := true
+		walSyncType := FlushOnCommit
+
+		var err error
+		twr, err = m.createTopic(WalTopicConfig{
+			Name:           consumerOffsetsTopic,
+			PartitionCount: 1,
+			WalSyncType:    &walSyncType,
+			Compact:        &compact,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	groups, err := newWalConsumerGroups(m, twr)
+	if err != nil {
+		return err
+	}
+
+	m.groups = groups
+
+	//Offsets left behind by a delete that failed to remove them.
+	for _, topic := range groups.committedTopics() {
+		if m.Topic(topic) == nil {
+			err = groups.topicDeleted(topic)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *WalTopicManager) openTopic(tc WalTopicConfig) (*WalTopicWriter, error) {
+	walSyncType := m.defaults.WalSyncType
+	if tc.WalSyncType != nil {
+		walSyncType = *tc.WalSyncType
+	}
+
+	maxRecordSize := m.defaults.MaxRecordSize
+	if tc.MaxRecordSize != nil {
+		maxRecordSize = *tc.MaxRecordSize
+	}
+
+	partitioner, err := NewPartitioner(tc.Partitioner)
+	if err != nil {
+		return nil, err
+	}
+
+	twr, err := NewTopicWriter(m.Dir, tc.Name, tc.PartitionCount, m.defaults.MaxSegmentSize, maxRecordSize, walSyncType, m.defaults.FlushTimeout, m.defaults.Quarantine)
+	if err != nil {
+		return nil, err
+	}
+
+	twr.SetPartitioner(partitioner)
+	return twr, nil
+}
+
+func (m *WalTopicManager) sortedConfigs() WalTopicsConfig {
+	ret := make(WalTopicsConfig, 0, len(m.configs))
+	for _, tc := range m.configs {
+		ret = append(ret, tc)
+	}
+
+	sort.Slice(ret, func(i1, i2 int) bool {
+		return ret[i1].Name < ret[i2].Name
+	})
+
+	return ret
+}
+
+func (m *WalTopicManager) readManifest() (WalTopicsConfig, error) {
+	return ReadTopicsManifest(m.Dir)
+}
+
+//ReadTopicsManifest reads the topics manifest of the data directory, an empty list if there is none.
+func ReadTopicsManifest(dir Path) (WalTopicsConfig, error) {
+	ret := WalTopicsConfig{}
+
+	f, err := os.Open(dir.Add(topicsManifest).String())
+	if os.IsNotExist(err) {
+		log.Debug("No topics manifest found in: ", dir)
+		return ret, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	err = ret.ReadConfig(&r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read topics manifest: %v", err)
+	}
+
+	return ret, nil
+}
+
+//writeManifest replaces the manifest atomically by writing a temp file and renaming it over the old one.
+func (m *WalTopicManager) writeManifest() error {
+	manifest := m.Dir.Add(topicsManifest)
+	tmp := manifest.AddExtension(".tmp")
+
+	f, err := os.OpenFile(tmp.String(), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	configs := m.sortedConfigs()
+	var w io.Writer = f
+	err = configs.WriteConfig(&w)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp.String())
+		return err
+	}
+
+	return os.Rename(tmp.String(), manifest.String())
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func testTopicDefaults() WalTopicDefaults {
+	return WalTopicDefaults{
+		MaxSegmentSize: 1024,
+		MaxRecordSize:  512,
+		MaxBatchSize:   4096,
+		WalSyncType:    NoFlush,
+	}
+}
+
+func TestTopicManagerReopensPersistedTopics(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	topics, err := NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Error("Failed to create topic manager: ", err)
+		return
+	}
+
+	syncType := FlushOnCommit
+	_, err = topics.CreateTopic(WalTopicConfig{Name: "Kept", PartitionCount: 3, WalSyncType: &syncType})
+	if err != nil {
+		t.Error("Failed to create topic: ", err)
+		return
+	}
+
+	_, err = topics.CreateTopic(WalTopicConfig{Name: "Dropped", PartitionCount: 1})
+	if err != nil {
+		t.Error("Failed to create topic: ", err)
+		return
+	}
+
+	_, err = topics.CreateTopic(WalTopicConfig{Name: "Kept", PartitionCount: 1})
+	if we, ok := err.(WalError); !ok || we.Code() != ErrTopicExists {
+		t.Error("Expected topic exists error but found: ", err)
+		return
+	}
+
+	err = topics.DeleteTopic("Dropped")
+	if err != nil {
+		t.Error("Failed to delete topic: ", err)
+		return
+	}
+
+	topics.Close()
+
+	topics, err = NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Error("Failed to reopen topic manager: ", err)
+		return
+	}
+	defer topics.Close()
+
+	configs := topics.Topics()
+	if len(configs) != 1 || configs[0].Name != "Kept" || configs[0].PartitionCount != 3 || *configs[0].WalSyncType != FlushOnCommit {
+		t.Error("Unexpected topics after reopen: ", configs)
+		return
+	}
+
+	twr := topics.Topic("Kept")
+	if twr == nil || twr.PartitionCount() != 3 {
+		t.Error("Expected a writer for the reopened topic.")
+		return
+	}
+
+	err = <-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte("v")})
+	if err != nil {
+		t.Error("Failed to write to reopened topic: ", err)
+	}
+}
+
+func TestTopicManagerRejectsInvalidConfig(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	topics, err := NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Error("Failed to create topic manager: ", err)
+		return
+	}
+	defer topics.Close()
+
+	bogus := WalSyncType("Bogus")
+	negative := int64(-1)
+	for _, tc := range []WalTopicConfig{
+		{Name: "", PartitionCount: 1},
+		{Name: "../escape", PartitionCount: 1},
+		{Name: "__internal", PartitionCount: 1},
+		{Name: "NoPartitions", PartitionCount: 0},
+		{Name: "BadSync", PartitionCount: 1, WalSyncType: &bogus},
+		{Name: "BadRetention", PartitionCount: 1, RetentionMillis: &negative},
+		{Name: "BadPartitioner", PartitionCount: 1, Partitioner: "random"},
+	} {
+		_, err = topics.CreateTopic(tc)
+		if we, ok := err.(WalError); !ok || we.Code() != ErrInvalidTopicConfig {
+			t.Error("Expected invalid config error for: ", tc.Name, " but found: ", err)
+		}
+	}
+}
+
+func TestTopicManagerForgetsTopicsFailingToOpen(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	topics, err := NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Error("Failed to create topic manager: ", err)
+		return
+	}
+	defer topics.Close()
+
+	//A file where the topic directory goes.
+	err = ioutil.WriteFile(dir.Add("Broken").String(), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = topics.CreateTopic(WalTopicConfig{Name: "Broken", PartitionCount: 1})
+	if err == nil {
+		t.Error("Expected creating the topic to fail")
+		return
+	}
+
+	manifest, err := topics.readManifest()
+	if _, ok := topics.TopicConfig("Broken"); ok || err != nil || len(manifest) != 1 {
+		t.Error("Expected the topic to be forgotten: ", manifest, " ", err)
+	}
+}
+
+func TestTopicUsesConfiguredPartitioner(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	topics, err := NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Error("Failed to create topic manager: ", err)
+		return
+	}
+	defer topics.Close()
+
+	twr, err := topics.CreateTopic(WalTopicConfig{Name: "Spread", PartitionCount: 3, Partitioner: RoundRobinPartitioner})
+	if err != nil {
+		t.Error("Failed to create topic: ", err)
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		id, retChan := twr.WriteWalRecordWithID(&WalRecord{Key: "same", Value: []byte{byte(i)}})
+		if err := <-retChan; err != nil || id.Partition != int32(i) {
+			t.Error("Expected record ", i, " on partition ", i, " but found: ", id, " ", err)
+		}
+	}
+}
+
+func TestIncreasePartitions(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	topics, err := NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Error("Failed to create topic manager: ", err)
+		return
+	}
+
+	twr, err := topics.CreateTopic(WalTopicConfig{Name: "Grow", PartitionCount: 2, Partitioner: ConsistentPartitioner})
+	if err != nil {
+		t.Error("Failed to create topic: ", err)
+		topics.Close()
+		return
+	}
+
+	for i := 0; i < 4; i++ {
+		<-twr.WriteWalRecord(&WalRecord{Key: fmt.Sprint("before-", i), Value: []byte{byte(i)}})
+	}
+
+	a, err := topics.Groups().Join("readers", "r1", []string{"Grow"}, "", 0)
+	if err != nil {
+		t.Error("Failed to join: ", err)
+		topics.Close()
+		return
+	}
+
+	for _, count := range []uint32{2, 1} {
+		_, err = topics.IncreasePartitions("Grow", count, "")
+		if we, ok := err.(WalError); !ok || we.Code() != ErrInvalidTopicConfig {
+			t.Error("Expected shrinking to ", count, " to fail but found: ", err)
+		}
+	}
+
+	_, err = topics.IncreasePartitions("Grow", 4, Murmur2Partitioner)
+	if we, ok := err.(WalError); !ok || we.Code() != ErrInvalidTopicConfig {
+		t.Error("Expected switching the partitioner to fail but found: ", err)
+	}
+
+	tc, err := topics.IncreasePartitions("Grow", 4, "")
+	if err != nil || tc.PartitionCount != 4 || tc.Partitioner != ConsistentPartitioner || twr.PartitionCount() != 4 {
+		t.Error("Failed to increase partitions: ", tc, " ", err)
+		topics.Close()
+		return
+	}
+
+	current, err := topics.Groups().Heartbeat("readers", "r1")
+	if err != nil || current.Generation != a.Generation+1 || len(current.Assignment["Grow"]) != 4 {
+		t.Error("Expected the group to get the new partitions assigned: ", current, " ", err)
+	}
+
+	for p := uint32(2); p < 4; p++ {
+		_, retChan := twr.WriteWalRecordWithOptions(&WalRecord{Key: "after", Value: []byte{1}}, WalWriteOptions{Partitioner: ExplicitPartitioner(p)})
+		if err := <-retChan; err != nil {
+			t.Error("Failed to write to new partition: ", p, " ", err)
+		}
+	}
+
+	topics.Close()
+
+	topics, err = NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Error("Failed to reopen topic manager: ", err)
+		return
+	}
+	defer topics.Close()
+
+	twr = topics.Topic("Grow")
+	var total int64
+	for p := uint32(0); p < 4; p++ {
+		end, err := twr.NewReader().EndOffset(p)
+		if err != nil {
+			t.Error("Failed to read partition: ", p, " ", err)
+			return
+		}
+
+		total += end
+	}
+
+	if twr.PartitionCount() != 4 || total != 6 {
+		t.Error("Expected 4 partitions holding 6 records after restart but found: ", twr.PartitionCount(), " ", total)
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//WalPartitionEntry a record read back from a partition along with its logical offset.
+type WalPartitionEntry struct {
+	Offset int64
+	Record *WalExRecord
+	CrcOk  bool
+}
+
+//WalTopicReader opens cursors over the partitions of a topic.
+type WalTopicReader struct {
+	Dir            Path
+	PartitionCount uint32
+	MaxEntrySize   int64
+
+	notifiers []*WalNotifier
+}
+
+//NewWalTopicReader creates a reader over the topic data in topicDir. maxEntrySize bounds the entries considered valid, 0 disables it.
+func NewWalTopicReader(topicDir Path, partitionCount uint32, maxEntrySize int64) *WalTopicReader {
+	return &WalTopicReader{
+		Dir:            topicDir,
+		PartitionCount: partitionCount,
+		MaxEntrySize:   maxEntrySize,
+	}
+}
+
+//NewReader returns a reader over this topic whose cursors get woken up by this writer appends.
+func (w *WalTopicWriter) NewReader() *WalTopicReader {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	ret := NewWalTopicReader(w.Dir, uint32(len(w.partitions)), w.MaxEntrySize())
+	ret.notifiers = make([]*WalNotifier, len(w.partitions))
+	for i, p := range w.partitions {
+		ret.notifiers[i] = p.notifier
+	}
+
+	return ret
+}
+
+//Partition opens a cursor on the partition positioned at the logical offset.
+func (r *WalTopicReader) Partition(partition uint32, offset int64) (*WalPartitionCursor, error) {
+	ret := &WalPartitionCursor{
+		TopicDir:     r.Dir,
+		Partition:    partition,
+		MaxEntrySize: r.MaxEntrySize,
+	}
+
+	if int(partition) < len(r.notifiers) {
+		ret.Notifier = r.notifiers[partition]
+	}
+
+	err := ret.SeekTo(offset)
+	if err != nil {
+		ret.Close()
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+//PartitionSince opens a cursor on the partition positioned at the first record with a timestamp at or after timestamp.
+func (r *WalTopicReader) PartitionSince(partition uint32, timestamp int64) (*WalPartitionCursor, error) {
+	start, err := r.StartOffset(partition)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := r.Partition(partition, start)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ret.SeekToTime(timestamp)
+	if err != nil {
+		ret.Close()
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+//OffsetForTime returns the offset of the first record of the partition with a timestamp at or after timestamp,
+// the end offset when there is none.
+func (r *WalTopicReader) OffsetForTime(partition uint32, timestamp int64) (int64, error) {
+	cursor, err := r.PartitionSince(partition, timestamp)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+
+	return cursor.Position(), nil
+}
+
+//StartOffset returns the offset of the oldest entry the partition still keeps.
+func (r *WalTopicReader) StartOffset(partition uint32) (int64, error) {
+	return PartitionStartOffset(r.Dir.AddUint32(partition), r.MaxEntrySize)
+}
+
+//EndOffset returns the offset the next entry appended to the partition will get.
+func (r *WalTopicReader) EndOffset(partition uint32) (int64, error) {
+	return PartitionEndOffset(r.Dir.AddUint32(partition), r.MaxEntrySize)
+}
+
+//WalPartitionCursor reads a partition entry by entry, moving from one segment to the next in creation order.
+// The position is the logical offset of an entry counted from the start of the partition.
+type WalPartitionCursor struct {
+	TopicDir     Path
+	Partition    uint32
+	MaxEntrySize int64
+	Notifier     *WalNotifier
+
+	segment  string
+	reader   *WalPartitionReader
+	index    []WalIndexEntry
+	position int64
+	skipTo   int64
+}
+
+//Position returns the logical offset of the next entry to be read.
+func (c *WalPartitionCursor) Position() int64 {
+	if c.skipTo > c.position {
+		return c.skipTo
+	}
+
+	return c.position
+}
+
+//SeekTo positions the cursor at the logical offset. Seeking past the end makes the cursor wait for that offset.
+// The segment holding the offset is found from the segment base offsets and the read starts from its closest index entry.
+// Offsets before the first segment were deleted by retention and give ErrOffsetOutOfRange.
+func (c *WalPartitionCursor) SeekTo(offset int64) error {
+	c.closeReader()
+	c.segment = ""
+	c.position = 0
+	c.skipTo = offset
+
+	segments, err := LoadPartitionSegments(c.TopicDir.AddUint32(c.Partition), c.MaxEntrySize)
+	if err != nil {
+		return err
+	}
+
+	if len(segments) > 0 && offset < segments[0].Index.BaseOffset {
+		return NewWalError(ErrOffsetOutOfRange, fmt.Sprintf("Offset %d is out of range, the partition starts at %d.", offset, segments[0].Index.BaseOffset))
+	}
+
+	i := sort.Search(len(segments), func(i int) bool {
+		return segments[i].Index.BaseOffset > offset
+	})
+
+	if i > 0 {
+		s := segments[i-1]
+		e := s.Index.Lookup(offset)
+		err = c.openSegment(s.Name)
+		if err != nil {
+			return err
+		}
+
+		err = c.reader.SeekTo(e.Position)
+		if err != nil {
+			return err
+		}
+
+		c.position = e.Offset
+		c.skipIndexGap()
+	}
+
+	for c.position < c.skipTo {
+		_, err := c.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//SeekToTime positions the cursor at the first record with a timestamp at or after timestamp, or at the end of the partition.
+// Sealed segments whose newest record is older are skipped whole, the scan starts from the closest time index entry.
+func (c *WalPartitionCursor) SeekToTime(timestamp int64) error {
+	segments, err := LoadPartitionSegments(c.TopicDir.AddUint32(c.Partition), c.MaxEntrySize)
+	if err != nil {
+		return err
+	}
+
+	var start int64
+	for i, s := range segments {
+		timeIndex, err := loadSegmentTimeIndex(s, c.MaxEntrySize)
+		if err != nil {
+			return err
+		}
+
+		start = timeIndex.Lookup(s.Index.BaseOffset, timestamp)
+		last, ok := timeIndex.Last()
+		sealed := i+1 < len(segments) && ok && last.Offset == segments[i+1].Index.BaseOffset
+		if !sealed || last.Timestamp >= timestamp {
+			break
+		}
+
+		start = last.Offset
+	}
+
+	err = c.SeekTo(start)
+	if err != nil {
+		return err
+	}
+
+	for {
+		e, err := c.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if e.Record.ID.Timestamp >= timestamp {
+			return c.SeekTo(e.Offset)
+		}
+	}
+}
+
+//SeekToEnd moves the cursor after the last entry and returns its position.
+func (c *WalPartitionCursor) SeekToEnd() (int64, error) {
+	end, err := PartitionEndOffset(c.TopicDir.AddUint32(c.Partition), c.MaxEntrySize)
+	if err != nil {
+		return 0, err
+	}
+
+	err = c.SeekTo(end)
+	if err != nil {
+		return 0, err
+	}
+
+	//Pick up whatever got appended meanwhile.
+	c.skipTo = 0
+
+	for {
+		_, err := c.read()
+		if err == io.EOF {
+			return c.position, nil
+		} else if err != nil {
+			return c.position, err
+		}
+	}
+}
+
+//Next returns the entry at the cursor position. Returns io.EOF when the cursor reached the end of the partition.
+func (c *WalPartitionCursor) Next() (*WalPartitionEntry, error) {
+	for {
+		e, err := c.read()
+		if err != nil || e.Offset >= c.skipTo {
+			return e, err
+		}
+	}
+}
+
+//NextWait behaves like Next but at the end of the partition it waits for the writer to append.
+// Returns io.EOF if nothing was appended before ctx is done or when there is no Notifier.
+func (c *WalPartitionCursor) NextWait(ctx context.Context) (*WalPartitionEntry, error) {
+	for {
+		var wake <-chan struct{}
+		if c.Notifier != nil {
+			wake = c.Notifier.Wait()
+		}
+
+		e, err := c.Next()
+		if err != io.EOF || wake == nil {
+			return e, err
+		}
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, io.EOF
+		}
+	}
+}
+
+//ReadEntries waits until ctx is done for at least one entry and returns up to max entries.
+func (c *WalPartitionCursor) ReadEntries(ctx context.Context, max int) ([]*WalPartitionEntry, error) {
+	ret := make([]*WalPartitionEntry, 0)
+
+	e, err := c.NextWait(ctx)
+	for err == nil {
+		ret = append(ret, e)
+		if len(ret) >= max {
+			break
+		}
+
+		e, err = c.Next()
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+
+	return ret, err
+}
+
+//Close closes the segment currently open.
+func (c *WalPartitionCursor) Close() {
+	c.closeReader()
+}
+
+//read returns the next entry regardless of skipTo.
+func (c *WalPartitionCursor) read() (*WalPartitionEntry, error) {
+	if c.reader == nil {
+		next, err := c.nextSegment()
+		if err != nil {
+			return nil, err
+		}
+
+		if next == "" {
+			return nil, io.EOF
+		}
+
+		err = c.openSegment(next)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	retried := false
+	for {
+		start := c.reader.CurrentOffset
+		wr, _, err := c.reader.ReadNextEntry()
+		if err == nil || err == ErrWrongChecksum {
+			wr.ID.Partition = int32(c.Partition)
+			e := &WalPartitionEntry{
+				Offset: c.position,
+				Record: wr,
+				CrcOk:  err == nil,
+			}
+
+			c.position++
+			c.skipIndexGap()
+			return e, nil
+		}
+
+		//Either the end of the segment, an entry not completely flushed yet or a corrupted tail.
+		next, lerr := c.nextSegment()
+		if lerr != nil {
+			return nil, lerr
+		}
+
+		serr := c.reader.SeekTo(start)
+		if serr != nil {
+			return nil, serr
+		}
+
+		if next == "" {
+			return nil, io.EOF
+		}
+
+		if !retried {
+			//A newer segment means this one is sealed, give its tail one more read.
+			retried = true
+			continue
+		}
+
+		if err != io.EOF {
+			log.Warn("Skipping corrupted tail of segment: ", c.segment, " at: ", start, " ", err)
+		}
+
+		err = c.openSegment(next)
+		if err != nil {
+			return nil, err
+		}
+
+		retried = false
+	}
+}
+
+//nextSegment returns the segment created after the current one, an empty string if there is none.
+func (c *WalPartitionCursor) nextSegment() (string, error) {
+	segments, err := ListWalSegments(c.TopicDir.AddUint32(c.Partition).String())
+	if err != nil {
+		return "", err
+	}
+
+	//Compaction may have replaced the current segment with a newer generation meanwhile.
+	current := walSegmentStem(c.segment)
+	for _, s := range segments {
+		if walSegmentStem(s) > current {
+			return s, nil
+		}
+	}
+
+	return "", nil
+}
+
+func (c *WalPartitionCursor) openSegment(segment string) error {
+	c.closeReader()
+
+	log.Debug("Opening segment: ", segment, " of partition: ", c.Partition)
+	reader, err := NewWalPartitionReader(c.TopicDir.String(), c.Partition, segment)
+	if err != nil {
+		return err
+	}
+
+	reader.MaxEntrySize = c.MaxEntrySize
+	c.reader = reader
+	c.segment = segment
+
+	//Segments without an index continue counting from the previous one.
+	idx, err := ReadSegmentIndex(c.TopicDir.AddUint32(c.Partition).Add(segment).String())
+	if err == nil {
+		c.position = idx.BaseOffset
+		c.index = idx.Entries
+		c.skipIndexGap()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+//skipIndexGap moves the position past the offsets compaction removed in front of the reader.
+func (c *WalPartitionCursor) skipIndexGap() {
+	c.index, c.position = skipIndexGap(c.index, c.reader.CurrentOffset, c.position)
+}
+
+func (c *WalPartitionCursor) closeReader() {
+	if c.reader != nil {
+		c.reader.Close()
+		c.reader = nil
+	}
+
+	c.index = nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCursorWalksAllSegments(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	twr, err := NewTopicWriter(dir, "Test", 1, 40, 0, NoFlush, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer twr.Close()
+
+	count := 10
+	for i := 0; i < count; i++ {
+		err := <-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte(fmt.Sprint(i))})
+		if err != nil {
+			t.Error("Failed to write record: ", err)
+			return
+		}
+	}
+
+	segments, _ := ListWalSegments(dir.Add("Test").AddUint32(0).String())
+	if len(segments) < 3 {
+		t.Error("Expected the partition to roll over several segments but found: ", segments)
+		return
+	}
+
+	cursor, err := twr.NewReader().Partition(0, 3)
+	if err != nil {
+		t.Error("Failed to open cursor: ", err)
+		return
+	}
+	defer cursor.Close()
+
+	for i := 3; i < count; i++ {
+		e, err := cursor.Next()
+		if err != nil {
+			t.Error("Failed to read entry: ", i, " ", err)
+			return
+		}
+
+		if e.Offset != int64(i) || string(e.Record.Record.Value) != fmt.Sprint(i) || !e.CrcOk {
+			t.Error("Unexpected entry at: ", i, " offset: ", e.Offset, " value: ", string(e.Record.Record.Value))
+			return
+		}
+	}
+
+	_, err = cursor.Next()
+	if err != io.EOF || cursor.Position() != int64(count) {
+		t.Error("Expected EOF at position ", count, " but found: ", err, " ", cursor.Position())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte("late")})
+	}()
+
+	e, err := cursor.NextWait(ctx)
+	if err != nil || string(e.Record.Record.Value) != "late" || e.Offset != int64(count) {
+		t.Error("Expected to be woken up by the late record but found: ", err)
+	}
+}
+
+func TestCursorSeekPastEndWaitsForOffset(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	twr, err := NewTopicWriter(dir, "Test", 1, 1024, 0, NoFlush, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer twr.Close()
+
+	<-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte("0")})
+
+	cursor, err := twr.NewReader().Partition(0, 2)
+	if err != nil {
+		t.Error("Failed to open cursor: ", err)
+		return
+	}
+	defer cursor.Close()
+
+	<-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte("1")})
+	<-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte("2")})
+
+	e, err := cursor.Next()
+	if err != nil || e.Offset != 2 || string(e.Record.Record.Value) != "2" {
+		t.Error("Expected the entry at offset 2 but found: ", e, " ", err)
+	}
+}
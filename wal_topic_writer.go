@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -11,96 +12,510 @@ import (
 
 type ctxKey string
 
+//maxGroupCommitRecords caps how many records a partition handler coalesces before flushing.
+const maxGroupCommitRecords = 1024
+
+//partitionQueueSize how many requests a partition queues while its handler commits, writers block once it is full.
+// Unacknowledged writes return as soon as they are queued.
+const partitionQueueSize = 1024
+
 //WalTopicWriter writes to a topic and handles file swapping and so on.
+// Partitions only ever get added, guarded by mutex along with the partitioner.
 type WalTopicWriter struct {
-	PartitionCount uint32
 	Name           string
+	Dir            Path
 	maxSegmentSize int64
-	partitions     []*WalPartition
+	maxRecordSize  int64
+	walSyncType    WalSyncType
+	flushTimeout   time.Duration
+	quarantine     bool
 	topicChannel   chan *WalRecord
 
-	ctx             context.Context
-	cancel          context.CancelFunc
-	currentSequence uint32
+	mutex       sync.RWMutex
+	partitioner Partitioner
+	partitions  []*WalPartition
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	handlers  sync.WaitGroup
+	closeOnce sync.Once
+}
+
+//WalAckLevel sets how far a write gets before it is acknowledged.
+type WalAckLevel string
+
+const (
+
+	//AckNone acknowledges once the records are queued, before the write. Errors past validation only get logged. The record id is not known.
+	AckNone WalAckLevel = "none"
+
+	//AckBuffered acknowledges once the records are in the write buffer, before readers can see them.
+	AckBuffered WalAckLevel = "buffered"
+
+	//AckFlushed acknowledges once the records are flushed to the file handle. The default of NoFlush topics.
+	AckFlushed WalAckLevel = "flushed"
+
+	//AckSynced acknowledges once the records are synced to disk. The default of FlushOnCommit topics.
+	AckSynced WalAckLevel = "synced"
+)
+
+//Valid checks the ack level is one of the known values.
+func (a WalAckLevel) Valid() bool {
+	return a == AckNone || a == AckBuffered || a == AckFlushed || a == AckSynced
+}
+
+//WalWriteOptions changes how a write gets handled, the zero value writes like WriteWalRecord.
+type WalWriteOptions struct {
+	//Partitioner picks the partitions instead of the one of the topic when not nil.
+	Partitioner Partitioner
+
+	//Ack overrides when the write is acknowledged, the topic sync type decides when empty.
+	Ack WalAckLevel
 }
 
 type walRequest struct {
-	walRecord *WalExRecord
-	respChan  chan error
+	walRecords []*WalExRecord
+	respChan   chan error
+	ack        WalAckLevel
+}
+
+//complete hands the result to the writer waiting for it, fire and forget writes only get their failures logged.
+func (r *walRequest) complete(err error) {
+	if r.respChan == nil {
+		if err != nil {
+			log.Warn("Failed unacknowledged write: ", err)
+		}
+
+		return
+	}
+
+	r.respChan <- err
 }
 
 //WalPartition wraps the partition writer and a channel to send events to.
+// sequence and timestamp are the last ones assigned, only touched by the partition handler.
 type WalPartition struct {
 	writerChannel   chan *walRequest
 	partitionWriter *WalPartitionWriter
+	notifier        *WalNotifier
+	sequence        uint64
+	timestamp       int64
+	recovery        *WalRecoveryReport
 }
 
-//Close closes topic writer and releases all resources.
-func (w *WalTopicWriter) Close() error {
-	log.Debug("Closing topic writer.")
-	if w.cancel != nil {
-		w.cancel()
+//MaxEntrySize returns the largest entry size a record of this topic can take on disk, 0 if unbounded.
+func (w *WalTopicWriter) MaxEntrySize() int64 {
+	if w.maxRecordSize <= 0 {
+		return 0
+	}
+
+	return w.maxRecordSize + WalExRecordOverhead
+}
+
+//PartitionCount returns the number of partitions of the topic.
+func (w *WalTopicWriter) PartitionCount() uint32 {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	return uint32(len(w.partitions))
+}
+
+//SetPartitioner replaces the partitioner picking the partitions of records written without one of their own.
+func (w *WalTopicWriter) SetPartitioner(p Partitioner) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.partitioner = p
+}
+
+//Recovery returns what got dropped from the partition newest segment when the writer was opened, nil if nothing.
+func (w *WalTopicWriter) Recovery(partition uint32) *WalRecoveryReport {
+	return w.partition(partition).recovery
+}
+
+//Notifier returns the notifier signaled after every flushed write to the partition.
+func (w *WalTopicWriter) Notifier(partition uint32) *WalNotifier {
+	return w.partition(partition).notifier
+}
+
+func (w *WalTopicWriter) partition(partition uint32) *WalPartition {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	return w.partitions[partition]
+}
+
+//AddPartitions grows the topic to partitionCount partitions and starts writing to them. Existing partitions keep their
+// records, the partitioner decides which keys move to the new ones.
+func (w *WalTopicWriter) AddPartitions(partitionCount uint32) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	//Checked under the lock Close cancels with, so no handler gets started while Close waits for them.
+	if w.ctx.Err() != nil {
+		return w.ctx.Err()
 	}
 
-	log.Debug("Closing topic channel.")
-	close(w.topicChannel)
-	for idx, p := range w.partitions {
-		log.Debug("Closing partition channel: ", idx)
-		close(p.writerChannel)
-		err := p.partitionWriter.Close()
+	current := uint32(len(w.partitions))
+	if partitionCount <= current {
+		return NewWalError(ErrInvalidTopicConfig, fmt.Sprint("Partition count can only grow from: ", current, " requested: ", partitionCount))
+	}
+
+	added := make([]*WalPartition, 0, partitionCount-current)
+	for i := current; i < partitionCount; i++ {
+		wp, err := w.openPartition(i)
 		if err != nil {
-			log.Warn("Failed to close partition writer: ", err)
+			for _, p := range added {
+				p.partitionWriter.Close()
+			}
+
+			return err
 		}
+
+		added = append(added, wp)
 	}
 
+	w.partitions = append(w.partitions, added...)
+	w.startHandlers(current)
+
+	log.Info("Topic: ", w.Name, " grew from: ", current, " to: ", partitionCount, " partitions.")
+	return nil
+}
+
+//openPartition opens the writer of the partition, continuing the sequence of records already in it.
+func (w *WalTopicWriter) openPartition(i uint32) (*WalPartition, error) {
+	sequence, err := PartitionLastSequence(w.Dir.AddUint32(i), w.MaxEntrySize())
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp, err := PartitionLastTimestamp(w.Dir.AddUint32(i), w.MaxEntrySize())
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug("Partition: ", i, " continues from sequence: ", sequence)
+	pw, recovery, err := newWalPartitionWriter(w.Dir, i, w.maxSegmentSize, w.MaxEntrySize(), w.walSyncType, w.quarantine)
+	if err != nil {
+		return nil, err
+	}
+
+	//Stored for the sealed segments retention and compaction may empty.
+	err = WritePartitionSequence(w.Dir.AddUint32(i), sequence)
+	if err != nil {
+		pw.Close()
+		return nil, err
+	}
+
+	return &WalPartition{
+		partitionWriter: pw,
+		writerChannel:   make(chan *walRequest, partitionQueueSize),
+		notifier:        NewWalNotifier(),
+		sequence:        sequence,
+		timestamp:       timestamp,
+		recovery:        recovery,
+	}, nil
+}
+
+//startHandlers starts the handlers of the partitions from the first one on. Expects the lock to be held.
+func (w *WalTopicWriter) startHandlers(first uint32) {
+	w.handlers.Add(len(w.partitions) - int(first))
+	for i := first; i < uint32(len(w.partitions)); i++ {
+		go func(i uint32, wp *WalPartition) {
+			defer w.handlers.Done()
+			partitionHandler(w.ctx, i, wp, w.flushTimeout)
+		}(i, w.partitions[i])
+	}
+}
+
+//Close closes topic writer and releases all resources. Pending writes complete before the files are closed.
+func (w *WalTopicWriter) Close() error {
+	w.closeOnce.Do(func() {
+		log.Debug("Closing topic writer.")
+		w.mutex.Lock()
+		if w.cancel != nil {
+			w.cancel()
+		}
+		w.mutex.Unlock()
+
+		log.Debug("Waiting for partition handlers.")
+		w.handlers.Wait()
+
+		//Nothing gets queued once cancelled, write what the handlers left behind.
+		for _, p := range w.partitions {
+			for queued := true; queued; {
+				select {
+				case wReq := <-p.writerChannel:
+					commitGroup(context.Background(), p, wReq, 0)
+				default:
+					queued = false
+				}
+			}
+		}
+
+		log.Debug("Closing topic channel.")
+		close(w.topicChannel)
+		for idx, p := range w.partitions {
+			log.Debug("Closing partition writer: ", idx)
+			err := p.partitionWriter.Close()
+			if err != nil {
+				log.Warn("Failed to close partition writer: ", err)
+			}
+		}
+	})
+
 	return nil
 }
 
 //WriteWalRecord writes wal records to different partitions.
 // The channel returned gets owned and closed by receiver.
 func (w *WalTopicWriter) WriteWalRecord(r *WalRecord) chan error {
+	_, retChan := w.WriteWalRecordWithID(r)
+	return retChan
+}
+
+//WriteWalRecordWithID writes wal records to different partitions and returns the id assigned to the record.
+// The id is only valid once the returned channel yields a nil error.
+func (w *WalTopicWriter) WriteWalRecordWithID(r *WalRecord) (*WalRecordID, chan error) {
+	return w.WriteWalRecordWithOptions(r, WalWriteOptions{})
+}
+
+//WriteWalRecordWithOptions writes the wal record like WriteWalRecordWithID, as set by opts.
+// Writes acknowledged with AckNone return no id.
+func (w *WalTopicWriter) WriteWalRecordWithOptions(r *WalRecord, opts WalWriteOptions) (*WalRecordID, chan error) {
 	log.Debug("Received object: ", r)
 	log.Debug("Creating return channel.")
-	retChan := make(chan error)
+	retChan := make(chan error, 1)
 
 	if w.ctx.Err() != nil {
 		log.Warnln("Context closed: ", w.ctx.Err())
-		go func() {
-			retChan <- w.ctx.Err()
-		}()
-
-		return retChan
+		retChan <- w.ctx.Err()
+		return nil, retChan
 	}
 
-	w.currentSequence++
-	log.Debug("Increased current sequence: ", w.currentSequence)
-	crc, err := Crc32([]byte(r.Key))
-	log.Debug("Calculated crc: ", crc)
+	ack, err := w.ackLevel(opts.Ack)
 	if err != nil {
-		go func() {
-			retChan <- err
-		}()
-		return retChan
+		retChan <- err
+		return nil, retChan
+	}
+
+	wrExs, errs := w.newPartitionedRecords([]*WalRecord{r}, opts.Partitioner)
+	if errs[0] != nil {
+		retChan <- errs[0]
+		return nil, retChan
 	}
 
-	partition := (crc % w.PartitionCount)
-	log.Debug("Selected partition: ", partition)
-	pObj := w.partitions[partition]
+	wrEx := wrExs[0]
+	wReq := &walRequest{[]*WalExRecord{wrEx}, retChan, ack}
+	id := wrEx.ID
+	if ack == AckNone {
+		//The handler assigns the sequence while the caller may already look at the id.
+		wReq.respChan = nil
+		id = nil
+		retChan <- nil
+	}
 
-	wrEx := NewWalExRecord(r, w.currentSequence, time.Now().UnixNano())
 	log.Debug("Sending walExRecord to the partition channel: ", wrEx.Record.Key)
-	pObj.writerChannel <- &walRequest{wrEx, retChan}
+	err = w.send(wrEx.ID.Partition, wReq)
+	if err != nil {
+		if ack == AckNone {
+			log.Warn("Failed unacknowledged write: ", err)
+			return nil, retChan
+		}
+
+		retChan <- err
+		return nil, retChan
+	}
 	log.Debug("Sent walExRecord to the partition channel ...")
 
-	return retChan
+	return id, retChan
+}
+
+//WriteWalRecords writes a batch of wal records. Records are grouped by partition and each group
+// is written contiguously and flushed once. The returned ids line up with records and are only valid
+// once the returned channel yields; it yields one error per record.
+func (w *WalTopicWriter) WriteWalRecords(records []*WalRecord) ([]*WalRecordID, chan []error) {
+	return w.WriteWalRecordsWithOptions(records, WalWriteOptions{})
+}
+
+//WriteWalRecordsWithOptions writes the batch like WriteWalRecords, as set by opts.
+// With AckNone the returned channel only yields the errors of records failing validation and no ids are returned.
+func (w *WalTopicWriter) WriteWalRecordsWithOptions(records []*WalRecord, opts WalWriteOptions) ([]*WalRecordID, chan []error) {
+	log.Debug("Received batch of: ", len(records))
+	retChan := make(chan []error, 1)
+	errs := make([]error, len(records))
+	ids := make([]*WalRecordID, len(records))
+
+	if w.ctx.Err() != nil {
+		log.Warnln("Context closed: ", w.ctx.Err())
+		for i := range errs {
+			errs[i] = w.ctx.Err()
+		}
+
+		retChan <- errs
+		return ids, retChan
+	}
+
+	ack, err := w.ackLevel(opts.Ack)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+
+		retChan <- errs
+		return ids, retChan
+	}
+
+	wrExs, partitionErrs := w.newPartitionedRecords(records, opts.Partitioner)
+	groups := make(map[int32][]*WalExRecord)
+	indexes := make(map[int32][]int)
+	for i, wrEx := range wrExs {
+		if partitionErrs[i] != nil {
+			errs[i] = partitionErrs[i]
+			continue
+		}
+
+		if ack != AckNone {
+			ids[i] = wrEx.ID
+		}
+
+		groups[wrEx.ID.Partition] = append(groups[wrEx.ID.Partition], wrEx)
+		indexes[wrEx.ID.Partition] = append(indexes[wrEx.ID.Partition], i)
+	}
+
+	if ack == AckNone {
+		retChan <- errs
+		for partition, group := range groups {
+			wReq := &walRequest{group, nil, ack}
+			err := w.send(partition, wReq)
+			if err != nil {
+				wReq.complete(err)
+			}
+		}
+
+		return ids, retChan
+	}
+
+	respChans := make(map[int32]chan error)
+	for partition, group := range groups {
+		log.Debug("Sending ", len(group), " records to partition: ", partition)
+		respChans[partition] = make(chan error, 1)
+		err := w.send(partition, &walRequest{group, respChans[partition], ack})
+		if err != nil {
+			respChans[partition] <- err
+		}
+	}
+
+	go func() {
+		for partition, respChan := range respChans {
+			err := <-respChan
+			for _, i := range indexes[partition] {
+				errs[i] = err
+			}
+		}
+
+		retChan <- errs
+	}()
+
+	return ids, retChan
+}
+
+//ackLevel returns the ack level of a write, the one matching the topic sync type unless given.
+func (w *WalTopicWriter) ackLevel(ack WalAckLevel) (WalAckLevel, error) {
+	if ack == "" {
+		if w.walSyncType == FlushOnCommit {
+			return AckSynced, nil
+		}
+
+		return AckFlushed, nil
+	}
+
+	if !ack.Valid() {
+		return "", fmt.Errorf("Unknown ack level: %s", ack)
+	}
+
+	return ack, nil
+}
+
+//send queues the request for the partition handler unless the writer gets closed first. The read lock keeps Close
+// from cancelling while a request is queued, it writes whatever is left in the queues.
+func (w *WalTopicWriter) send(partition int32, wReq *walRequest) error {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	if w.ctx.Err() != nil {
+		return w.ctx.Err()
+	}
+
+	select {
+	case w.partitions[partition].writerChannel <- wReq:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+//newPartitionedRecords wraps the records and picks their partitions with partitioner, the one of the topic when nil.
+// Records too large are left out of partitioning and get their own error, the others share the error of the partitioner.
+func (w *WalTopicWriter) newPartitionedRecords(records []*WalRecord, partitioner Partitioner) ([]*WalExRecord, []error) {
+	ret := make([]*WalExRecord, len(records))
+	errs := make([]error, len(records))
+	valid := make([]*WalRecord, 0, len(records))
+	indexes := make([]int, 0, len(records))
+
+	for i, r := range records {
+		size := int64(len(r.Key) + len(r.Value))
+		if w.maxRecordSize > 0 && size > w.maxRecordSize {
+			errs[i] = NewWalError(ErrRecordSizeLimitReached, fmt.Sprintf("Record size %d exceeds max record size %d", size, w.maxRecordSize))
+			continue
+		}
+
+		valid = append(valid, r)
+		indexes = append(indexes, i)
+	}
+
+	if len(valid) == 0 {
+		return ret, errs
+	}
+
+	w.mutex.RLock()
+	partitionCount := uint32(len(w.partitions))
+	if partitioner == nil {
+		partitioner = w.partitioner
+	}
+	w.mutex.RUnlock()
+
+	partitions, err := partitioner.Partition(valid, partitionCount)
+	if err == nil && len(partitions) != len(valid) {
+		err = fmt.Errorf("Partitioner returned %d partitions for %d records", len(partitions), len(valid))
+	}
+
+	for j, i := range indexes {
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		if partitions[j] >= partitionCount {
+			errs[i] = NewWalError(ErrInvalidPartition, fmt.Sprint("No such partition: ", partitions[j]))
+			continue
+		}
+
+		log.Debug("Selected partition: ", partitions[j])
+
+		//The sequence and timestamp get assigned by the partition handler.
+		ret[i] = NewWalExRecord(valid[j], 0, 0)
+		ret[i].ID.Partition = int32(partitions[j])
+	}
+
+	return ret, errs
 }
 
-func partitionHandler(ctx context.Context, partitionCount uint32, wp *WalPartition) {
+func partitionHandler(ctx context.Context, partitionCount uint32, wp *WalPartition, flushTimeout time.Duration) {
 
 	log.Debug("Starting partition handler. Partition count:", partitionCount)
 
 	myCtx := context.WithValue(ctx, ctxKey(fmt.Sprint(partitionCount)), fmt.Sprint(partitionCount))
-	var wrEx *WalExRecord
 	var wReq *walRequest
 
 	readChan := wp.writerChannel
@@ -114,17 +529,11 @@ func partitionHandler(ctx context.Context, partitionCount uint32, wp *WalPartiti
 				return
 			}
 
-			log.Debug("Reading in key: ", wReq.walRecord.Record.Key)
-
-			wrEx = wReq.walRecord
-			b, err := wrEx.Bytes()
-			if err != nil {
-				wReq.respChan <- err
-				continue
+			if commitGroup(myCtx, wp, wReq, flushTimeout) {
+				log.Warn("Nil value sent to topic writer channel.")
+				return
 			}
 
-			writeWalExRecord(wReq.respChan, wp, b)
-
 		case <-myCtx.Done():
 			return
 		}
@@ -133,7 +542,151 @@ func partitionHandler(ctx context.Context, partitionCount uint32, wp *WalPartiti
 
 }
 
-func writeWalExRecord(respChan chan error, wp *WalPartition, b []byte) {
+//commitGroup writes first along with the requests queued behind it. A queued request means more are on their way,
+// the ones arriving are written too until the group is full or the flush timeout fires. Then it flushes once and
+// completes all of them together. Requests get completed at their ack level: buffered ones once appended, flushed ones
+// after the flush and synced ones after a sync, only done when one of them asks for it.
+// Returns true if the partition channel got closed.
+func commitGroup(ctx context.Context, wp *WalPartition, first *walRequest, flushTimeout time.Duration) bool {
+	pending := []*walRequest{}
+	errs := []error{}
+	count := 0
+	closed := false
+	needSync := false
+
+	add := func(wReq *walRequest) {
+		log.Debug("Reading in records: ", len(wReq.walRecords))
+		err := appendWalExRecords(wp, wReq.walRecords)
+		count += len(wReq.walRecords)
+		if wReq.ack == AckNone || wReq.ack == AckBuffered {
+			wReq.complete(err)
+			return
+		}
+
+		needSync = needSync || wReq.ack == AckSynced
+		pending = append(pending, wReq)
+		errs = append(errs, err)
+	}
+
+	add(first)
+
+	//Only started once a second request is queued, a single write on an idle partition commits at once.
+	var timer *time.Timer
+
+collect:
+	for count < maxGroupCommitRecords {
+		var wReq *walRequest
+
+		select {
+		case wReq = <-wp.writerChannel:
+		default:
+			if timer == nil {
+				break collect
+			}
+
+			select {
+			case wReq = <-wp.writerChannel:
+			case <-timer.C:
+				break collect
+			case <-ctx.Done():
+				break collect
+			}
+		}
+
+		if wReq == nil {
+			closed = true
+			break
+		}
+
+		if timer == nil && flushTimeout > 0 {
+			timer = time.NewTimer(flushTimeout)
+			defer timer.Stop()
+		}
+
+		add(wReq)
+	}
+
+	log.Debug("Committing ", count, " records from ", len(pending), " requests.")
+	err := flushWalPartition(wp)
+	for i, wReq := range pending {
+		if errs[i] == nil {
+			errs[i] = err
+		}
+
+		if wReq.ack == AckFlushed {
+			wReq.complete(errs[i])
+		}
+	}
+
+	if !needSync {
+		return closed
+	}
+
+	if err == nil {
+		log.Debug("Syncing to disk.")
+		err = wp.partitionWriter.Sync()
+	}
+
+	for i, wReq := range pending {
+		if wReq.ack != AckSynced {
+			continue
+		}
+
+		if errs[i] == nil {
+			errs[i] = err
+		}
+
+		wReq.complete(errs[i])
+	}
+
+	return closed
+}
+
+//appendWalExRecords assigns the next partition sequences to the records and appends them without flushing. Their
+// timestamps are taken along, never before the last one of the partition even if the clock goes back.
+func appendWalExRecords(wp *WalPartition, records []*WalExRecord) error {
+	now := time.Now().UnixNano()
+	if now < wp.timestamp {
+		now = wp.timestamp
+	}
+
+	for _, wrEx := range records {
+		wp.sequence++
+		wp.timestamp = now
+		wrEx.ID.Sequence = wp.sequence
+		wrEx.ID.Timestamp = now
+		err := wrEx.UpdateCrc()
+		if err != nil {
+			return err
+		}
+
+		b, err := wrEx.Bytes()
+		if err != nil {
+			return err
+		}
+
+		err = writeWalExRecord(wp, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//flushWalPartition flushes the partition without syncing, commitGroup syncs when a request asks for it.
+func flushWalPartition(wp *WalPartition) error {
+	pw := wp.partitionWriter
+	err := pw.FlushAndSync(false)
+	log.Debug("Flushed data, sync type: ", pw.WalSyncType)
+	if err == nil {
+		wp.notifier.Notify()
+	}
+
+	return err
+}
+
+func writeWalExRecord(wp *WalPartition, b []byte) error {
 	pw := wp.partitionWriter
 
 	log.Debug("Writing data to disk ...")
@@ -141,55 +694,64 @@ func writeWalExRecord(respChan chan error, wp *WalPartition, b []byte) {
 	if err == ErrSegLimitReached {
 		log.Warn("Error, segement size limit reached.")
 
+		//Synced first, the sync after the group commit only covers the new segment.
 		log.Debug("Closing current partition writer.")
+		err = wp.partitionWriter.FlushAndSync(true)
+		if err != nil {
+			return err
+		}
+
 		err = wp.partitionWriter.Close()
 		if err != nil {
-			respChan <- err
-			return
+			return err
+		}
+
+		//Covers the record being written too, sequences may skip one but never repeat.
+		err = WritePartitionSequence(*wp.partitionWriter.DirPath, wp.sequence)
+		if err != nil {
+			return err
 		}
 
 		fPath := GenFileName(wp.partitionWriter.DirPath.String())
 		maxSegSize := wp.partitionWriter.MaxSegmentSize
+		maxEntrySize := wp.partitionWriter.MaxEntrySize
 		walSyncType := wp.partitionWriter.WalSyncType
+		baseOffset := wp.partitionWriter.NextOffset
 
 		log.Debugf("Creating new partition writer: file: %s, maxSegSize: %d, walSyncType: %s", fPath, maxSegSize, walSyncType)
 
-		wp.partitionWriter, err = NewWalPartitionWriter(fPath, maxSegSize, walSyncType)
+		wp.partitionWriter, err = NewWalPartitionWriter(fPath, baseOffset, maxSegSize, maxEntrySize, walSyncType)
 		if err != nil {
-			respChan <- err
-			return
+			return err
 		}
 
 		log.Debug("Trying to write again.")
-		writeWalExRecord(respChan, wp, b)
-
-	} else if err != nil {
-		respChan <- err
-		return
-
-	} else {
-		err := pw.Flush()
-		log.Debug("Flushing data with setting: ", pw.WalSyncType)
-		respChan <- err
-		return
-
+		return writeWalExRecord(wp, b)
 	}
 
+	return err
 }
 
 //NewTopicWriter the actual topic writer.
-func NewTopicWriter(parentDir Path, name string, partitionCount uint32, maxSegmentSize int64, walSyncType WalSyncType) (*WalTopicWriter, error) {
+// Writes arriving within flushTimeout of the first pending one are committed with a single flush.
+// Records whose key and value take more than maxRecordSize bytes are rejected, 0 disables the check.
+// With quarantine the corrupted tail dropped from a reopened segment is kept in a file next to it.
+func NewTopicWriter(parentDir Path, name string, partitionCount uint32, maxSegmentSize int64, maxRecordSize int64, walSyncType WalSyncType, flushTimeout time.Duration, quarantine bool) (*WalTopicWriter, error) {
 
 	log.Debug("New topic writer.")
 	path := parentDir.Add(name)
 
 	log.Debug("Topic data path: ", path)
 	ret := &WalTopicWriter{
-		PartitionCount:  partitionCount,
-		Name:            name,
-		maxSegmentSize:  maxSegmentSize,
-		topicChannel:    make(chan *WalRecord),
-		currentSequence: 0,
+		Name:           name,
+		Dir:            path,
+		maxSegmentSize: maxSegmentSize,
+		maxRecordSize:  maxRecordSize,
+		walSyncType:    walSyncType,
+		flushTimeout:   flushTimeout,
+		quarantine:     quarantine,
+		partitioner:    &crc32Partitioner{},
+		topicChannel:   make(chan *WalRecord),
 	}
 
 	log.Debug("Creating partitions: ", partitionCount)
@@ -197,13 +759,18 @@ func NewTopicWriter(parentDir Path, name string, partitionCount uint32, maxSegme
 
 	var i uint32
 	for i = 0; i < partitionCount; i++ {
-		ret.partitions[i] = &WalPartition{
-			partitionWriter: newWalPartitionWriter(path, i, maxSegmentSize, walSyncType),
-			writerChannel:   make(chan *walRequest),
+		wp, err := ret.openPartition(i)
+		if err != nil {
+			for _, p := range ret.partitions[:i] {
+				p.partitionWriter.Close()
+			}
+
+			return nil, err
 		}
+
+		ret.partitions[i] = wp
 	}
 
-	//We assume nothing panicked so far.
 	log.Debug("Creating background context.")
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
@@ -225,23 +792,72 @@ func NewTopicWriter(parentDir Path, name string, partitionCount uint32, maxSegme
 		}
 	}(ret.ctx, cancel)
 
-	for i = 0; i < partitionCount; i++ {
-		go partitionHandler(ctx, i, ret.partitions[i])
-	}
+	ret.mutex.Lock()
+	ret.startHandlers(0)
+	ret.mutex.Unlock()
 
 	return ret, nil
 }
 
-func newWalPartitionWriter(topicDir Path, partitionCount uint32, maxSegmentSize int64, walSyncType WalSyncType) *WalPartitionWriter {
-	filePath := topicDir.AddUint32(partitionCount)
-	os.MkdirAll(filePath.String(), 644)
+//newWalPartitionWriter reopens the newest segment of the partition, or starts a new one when there is none or it is full.
+// The corrupted tail of the newest segment gets dropped first and reported.
+func newWalPartitionWriter(topicDir Path, partitionCount uint32, maxSegmentSize int64, maxEntrySize int64, walSyncType WalSyncType, quarantine bool) (*WalPartitionWriter, *WalRecoveryReport, error) {
+	partitionDir := topicDir.AddUint32(partitionCount)
+	err := os.MkdirAll(partitionDir.String(), os.ModePerm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	baseOffset, err := PartitionEndOffset(partitionDir, maxEntrySize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	last, err := ReturnLastCreatedWalFile(partitionDir.String())
+	if err != nil {
+		return nil, nil, err
+	}
 
-	filePath = filePath.AddInt64(time.Now().UnixNano()).AddExtension(".wal")
+	var recovery *WalRecoveryReport
+	if *last != "" {
+		log.Info("Reopening segment: ", *last)
+		recovery, err = recoverWalSegmentFile(*last, maxEntrySize, quarantine)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		wpw, err := NewWalPartitionWriter(*last, baseOffset, maxSegmentSize, maxEntrySize, walSyncType)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if wpw.CurrentOffset < maxSegmentSize {
+			return wpw, recovery, nil
+		}
+
+		log.Debug("Segment is full: ", *last)
+		baseOffset = wpw.NextOffset
+		err = wpw.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	filePath := partitionDir.AddInt64(time.Now().UnixNano()).AddExtension(".wal")
+	wpw, err := NewWalPartitionWriter(filePath.String(), baseOffset, maxSegmentSize, maxEntrySize, walSyncType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return wpw, recovery, nil
+}
 
-	wpw, err := NewWalPartitionWriter(filePath.String(), maxSegmentSize, walSyncType)
+func recoverWalSegmentFile(filePath string, maxEntrySize int64, quarantine bool) (*WalRecoveryReport, error) {
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0644)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer file.Close()
 
-	return wpw
+	return RecoverWalSegment(file, maxEntrySize, quarantine)
 }
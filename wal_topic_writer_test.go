@@ -2,9 +2,11 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -17,7 +19,7 @@ func BenchmarkTopicWriteSpeedNoSync(b *testing.B) {
 	b.ResetTimer()
 
 	tmpFile := pathTopic()
-	writer, err := NewTopicWriter(tmpFile, "Test", 4, 256, NoFlush)
+	writer, err := NewTopicWriter(tmpFile, "Test", 4, 256, 0, NoFlush, 0, false)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -43,3 +45,383 @@ func BenchmarkTopicWriteSpeedNoSync(b *testing.B) {
 	wg.Wait()
 	writer.Close()
 }
+
+func BenchmarkTopicWriteBatchSync(b *testing.B) {
+	b.ResetTimer()
+
+	tmpFile := pathTopic()
+	writer, err := NewTopicWriter(tmpFile, "TestBatch", 4, 1024*1024, 0, FlushOnCommit, 0, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	records := make([]*WalRecord, 0, 100)
+	for i := 0; i < b.N; i++ {
+		records = append(records, &WalRecord{
+			Key:   fmt.Sprint(i),
+			Value: []byte("asdasdasdda"),
+		})
+
+		if len(records) == cap(records) || i == b.N-1 {
+			_, errChan := writer.WriteWalRecords(records)
+			for _, err := range <-errChan {
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			records = records[:0]
+		}
+	}
+
+	writer.Close()
+}
+
+func TestGroupCommitCompletesAllWaiters(t *testing.T) {
+	dir := pathTopic().AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	writer, err := NewTopicWriter(dir, "TestGroup", 2, 1024*1024, 0, FlushOnCommit, 50*time.Millisecond, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	count := 50
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		go func(i int) {
+			errs <- <-writer.WriteWalRecord(&WalRecord{
+				Key:   fmt.Sprint(i),
+				Value: []byte("asdasdasdda"),
+			})
+		}(i)
+	}
+
+	for i := 0; i < count; i++ {
+		err := <-errs
+		if err != nil {
+			t.Error("Failed to write record: ", err)
+			return
+		}
+	}
+
+	var total int64
+	for p := uint32(0); p < 2; p++ {
+		end, err := writer.NewReader().EndOffset(p)
+		if err != nil {
+			t.Error("Failed to read partition: ", err)
+			return
+		}
+
+		total += end
+	}
+
+	if total != int64(count) {
+		t.Error("Expected ", count, " flushed records but found: ", total)
+	}
+}
+
+func TestSingleWriteDoesNotWaitForFlushTimeout(t *testing.T) {
+	dir := pathTopic().AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	flushTimeout := time.Second
+	writer, err := NewTopicWriter(dir, "TestLatency", 1, 1024*1024, 0, FlushOnCommit, flushTimeout, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	for _, ack := range []WalAckLevel{AckFlushed, AckSynced} {
+		start := time.Now()
+		_, retChan := writer.WriteWalRecordWithOptions(&WalRecord{Key: "k", Value: []byte(ack)}, WalWriteOptions{Ack: ack})
+		err := <-retChan
+		if err != nil || time.Since(start) > flushTimeout/4 {
+			t.Error("Expected a single ", ack, " write to commit at once: ", time.Since(start), " ", err)
+		}
+	}
+}
+
+func TestAckLevels(t *testing.T) {
+	dir := pathTopic().AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	flushTimeout := 300 * time.Millisecond
+	writer, err := NewTopicWriter(dir, "TestAck", 1, 1024*1024, 0, NoFlush, flushTimeout, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	write := func(ack WalAckLevel) (*WalRecordID, error) {
+		id, retChan := writer.WriteWalRecordWithOptions(&WalRecord{Key: "k", Value: []byte(ack)}, WalWriteOptions{Ack: ack})
+		return id, <-retChan
+	}
+
+	end := func() int64 {
+		ret, _ := writer.NewReader().EndOffset(0)
+		return ret
+	}
+
+	//Queued behind another write, the buffered one waits for the flush timeout gathering more.
+	pw := writer.partition(0).partitionWriter
+	pw.mutex.Lock()
+	id, err := write(AckNone)
+	if err != nil || id != nil {
+		pw.mutex.Unlock()
+		t.Error("Expected the unacknowledged write to return at once without an id: ", id, " ", err)
+		return
+	}
+
+	id, retChan := writer.WriteWalRecordWithOptions(&WalRecord{Key: "k", Value: []byte(AckBuffered)}, WalWriteOptions{Ack: AckBuffered})
+	start := time.Now()
+	pw.mutex.Unlock()
+	err = <-retChan
+	if err != nil || id == nil || time.Since(start) > flushTimeout/2 || end() != 0 {
+		t.Error("Expected the buffered write to be acknowledged before the flush: ", err, " ", time.Since(start), " ", end())
+		return
+	}
+
+	_, err = write(AckFlushed)
+	if err != nil || end() != 3 {
+		t.Error("Expected the flushed write to be readable: ", err, " ", end())
+		return
+	}
+
+	id, err = write(AckNone)
+	if err != nil || id != nil {
+		t.Error("Expected the unacknowledged write to return at once without an id: ", id, " ", err)
+		return
+	}
+
+	id, err = write(AckSynced)
+	if err != nil || id.Sequence != 5 || end() != 5 {
+		t.Error("Expected the synced write after the unacknowledged one: ", id, " ", err, " ", end())
+		return
+	}
+
+	_, err = write("eventually")
+	if err == nil {
+		t.Error("Expected an unknown ack level to fail")
+	}
+
+	ids, errChan := writer.WriteWalRecordsWithOptions([]*WalRecord{{Key: "a"}, {Key: "b"}}, WalWriteOptions{Ack: AckNone})
+	for i, err := range <-errChan {
+		if err != nil || ids[i] != nil {
+			t.Error("Unexpected unacknowledged batch result: ", ids[i], " ", err)
+		}
+	}
+}
+
+func TestSequenceSurvivesRestart(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	var last uint64
+	for run := 0; run < 3; run++ {
+		twr, err := NewTopicWriter(dir, "Test", 1, 1024, 0, NoFlush, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//The second run writes nothing.
+		for i := 0; i < 3 && run != 1; i++ {
+			id, retChan := twr.WriteWalRecordWithID(&WalRecord{Key: "k", Value: []byte("v")})
+			err := <-retChan
+			if err != nil {
+				twr.Close()
+				t.Fatal(err)
+			}
+
+			if id.Sequence != last+1 {
+				twr.Close()
+				t.Fatal("Expected sequence ", last+1, " but found: ", id.Sequence)
+			}
+
+			last = id.Sequence
+		}
+
+		twr.Close()
+	}
+
+	if last != 6 {
+		t.Error("Expected to end at sequence 6 but found: ", last)
+	}
+}
+
+func TestTimestampsNeverGoBack(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	twr, err := NewTopicWriter(dir, "Test", 1, 1024*1024, 0, NoFlush, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = <-twr.WriteWalRecord(&WalRecord{Key: "k"})
+	if err != nil {
+		twr.Close()
+		t.Fatal(err)
+	}
+
+	//As if the clock went back after the last write.
+	future := time.Now().Add(time.Hour).UnixNano()
+	twr.partition(0).timestamp = future
+
+	wg := &sync.WaitGroup{}
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				id, retChan := twr.WriteWalRecordWithID(&WalRecord{Key: "k"})
+				err := <-retChan
+				if err != nil || id.Timestamp != future {
+					t.Error("Expected the timestamp to stay at the last one: ", id, " ", err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	twr.Close()
+
+	twr, err = NewTopicWriter(dir, "Test", 1, 1024*1024, 0, NoFlush, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, retChan := twr.WriteWalRecordWithID(&WalRecord{Key: "k"})
+	err = <-retChan
+	twr.Close()
+	if err != nil || id.Timestamp != future {
+		t.Error("Expected the timestamp to continue from the last record after a restart: ", id, " ", err)
+		return
+	}
+
+	report, err := VerifyPartition(dir.Add("Test"), "Test", 0, 0)
+	if err != nil || len(report.Issues) != 0 {
+		t.Error("Unexpected verify report: ", report, " ", err)
+	}
+}
+
+func TestRestartReopensNewestSegment(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	partitionDir := dir.Add("Test").AddUint32(0)
+	for run := 0; run < 2; run++ {
+		twr, err := NewTopicWriter(dir, "Test", 1, 1024, 0, NoFlush, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 3; i++ {
+			err := <-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte(fmt.Sprint(run, i))})
+			if err != nil {
+				twr.Close()
+				t.Fatal(err)
+			}
+		}
+
+		twr.Close()
+
+		//A torn write left behind by a crash.
+		last, _ := ReturnLastCreatedWalFile(partitionDir.String())
+		f, err := os.OpenFile(*last, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f.Write([]byte{200, 0, 0, 0, 1, 2, 3})
+		f.Close()
+	}
+
+	segments, _ := ListWalSegments(partitionDir.String())
+	if len(segments) != 1 {
+		t.Error("Expected a single segment but found: ", segments)
+		return
+	}
+
+	cursor, err := NewWalTopicReader(dir.Add("Test"), 1, 0).Partition(0, 0)
+	if err != nil {
+		t.Error("Failed to open cursor: ", err)
+		return
+	}
+	defer cursor.Close()
+
+	for i := 0; i < 6; i++ {
+		e, err := cursor.Next()
+		if err != nil || string(e.Record.Record.Value) != fmt.Sprint(i/3, i%3) || !e.CrcOk {
+			t.Error("Unexpected entry at: ", i, " ", e, " ", err)
+			return
+		}
+	}
+}
+
+func TestOpenFailsWithoutPanic(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	//A file where the directory of the second partition goes.
+	err := os.MkdirAll(dir.Add("Test").String(), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(dir.Add("Test").AddUint32(1).String(), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewTopicWriter(dir, "Test", 2, 1024, 0, NoFlush, 0, false)
+	if err == nil {
+		t.Error("Expected opening a broken partition to fail")
+		return
+	}
+}
+
+func TestAckNoneDoesNotWaitForCommits(t *testing.T) {
+	dir := pathTopic().AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	writer, err := NewTopicWriter(dir, "TestAckNone", 1, 1024*1024, 0, FlushOnCommit, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	//Holding the partition writer keeps the handler inside the commit of the synced write.
+	pw := writer.partition(0).partitionWriter
+	pw.mutex.Lock()
+	synced := writer.WriteWalRecord(&WalRecord{Key: "synced"})
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		_, retChan := writer.WriteWalRecordWithOptions(&WalRecord{Key: fmt.Sprint("none", i)}, WalWriteOptions{Ack: AckNone})
+		if err := <-retChan; err != nil {
+			t.Error("Unexpected unacknowledged write error: ", err)
+		}
+	}
+
+	elapsed := time.Since(start)
+	pw.mutex.Unlock()
+	if elapsed > 50*time.Millisecond {
+		t.Error("Expected unacknowledged writes to return during the commit but took: ", elapsed)
+	}
+
+	err = <-synced
+	if err != nil {
+		t.Error("Synced write failed: ", err)
+		return
+	}
+
+	writer.Close()
+	end, err := writer.NewReader().EndOffset(0)
+	if err != nil || end != 11 {
+		t.Error("Expected every write to be written on close: ", end, " ", err)
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -33,94 +35,141 @@ func GenFileName(partitionDir string) string {
 	return fmt.Sprint(partitionDir, string(os.PathSeparator), time.Now().UnixNano(), ".wal")
 }
 
-//ReturnLastCreatedWalFile returns the last created wal segment file.
+//ReturnLastCreatedWalFile returns the newest wal segment file of the partition, an empty string if there is none.
 func ReturnLastCreatedWalFile(partitionDir string) (*string, error) {
-	files, err := ioutil.ReadDir(partitionDir)
+	segments, err := ListWalSegments(partitionDir)
 	if err != nil {
 		return nil, err
 	}
 
-	sort.Slice(files, func(i1, i2 int) bool {
-		return files[i1].Name() < files[i2].Name()
-	})
-
-	if len(files) == 0 {
-		ret := ""
-		return &ret, nil
+	ret := ""
+	if len(segments) > 0 {
+		ret = GenFileNameWith(partitionDir, segments[len(segments)-1])
 	}
 
-	ret := GenFileNameWith(partitionDir, files[0].Name())
 	return &ret, nil
 }
 
-func CreateTempWriter(partitionDir string, maxSegmentSize int64) (*os.File, *bufio.Writer, int64, error) {
-	var file *os.File
-	fileName, err := ReturnLastCreatedWalFile(partitionDir)
-	if err != nil || *fileName == "" {
-		*fileName = GenFileName(partitionDir)
+//ListWalSegments returns the names of the wal segments in the partition directory, oldest first.
+// Of a segment rewritten by compaction only the newest generation is returned.
+func ListWalSegments(partitionDir string) ([]string, error) {
+	names, err := listWalFiles(partitionDir)
+	if err != nil {
+		return nil, err
 	}
 
-	file, err = os.OpenFile(*fileName, os.O_CREATE|os.O_RDWR, 0644)
-	if err != nil {
-		return nil, nil, -1, err
+	//Generations sort before the original name: <stem>.<generation>.wal < <stem>.wal.
+	ret := make([]string, 0, len(names))
+	for i, name := range names {
+		stem := walSegmentStem(name)
+		if i+1 < len(names) && walSegmentStem(names[i+1]) == stem && names[i+1] != stem+".wal" {
+			continue
+		}
+
+		if len(ret) > 0 && walSegmentStem(ret[len(ret)-1]) == stem {
+			continue
+		}
+
+		ret = append(ret, name)
 	}
 
-	offset, err := MoveToLastValidWalEntry(file, maxSegmentSize)
-	if err != nil {
-		return nil, nil, -1, err
+	return ret, nil
+}
+
+//walSegmentStem returns the name of the segment without generation and extension, segments are ordered by it.
+func walSegmentStem(name string) string {
+	i := strings.Index(name, ".")
+	if i < 0 {
+		return name
 	}
 
-	if offset > maxSegmentSize {
-		oldFileName := fileName
-		*fileName = GenFileName(partitionDir)
-		log.Warn("File: ", *oldFileName, " exceeds size. Creating new one: ", *fileName)
-		file.Close()
+	return name[:i]
+}
 
-		file, err = os.OpenFile(*fileName, os.O_CREATE|os.O_RDWR, 0644)
-		if err != nil {
-			return nil, nil, -1, err
+//listWalFiles returns the names of every wal file in the partition directory, sorted by name.
+func listWalFiles(partitionDir string) ([]string, error) {
+	files, err := ioutil.ReadDir(partitionDir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".wal" {
+			continue
 		}
 
-		offset = 0
+		ret = append(ret, f.Name())
 	}
 
-	offset, err = file.Seek(offset, 0)
-	writer := bufio.NewWriter(file)
-
-	return file, writer, offset, nil
+	sort.Strings(ret)
+	return ret, nil
 }
 
-//MoveToLastValidWalEntry will move the offset to the end of last valid entry
+//MoveToLastValidWalEntry returns the end of the last entry passing the crc check, reading the file from its start.
+// An entry size above sizeLimit, or above what is left of the file, is treated as garbage and ends the valid part.
+// A sizeLimit of 0 leaves the size of the file as the only bound.
 func MoveToLastValidWalEntry(file *os.File, sizeLimit int64) (int64, error) {
-	var ret int64
 	var retValid int64
 	szBytes := []byte{0, 0, 0, 0}
 
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(file)
+
 	for {
-		n, err := io.ReadFull(file, szBytes)
-		if n == 0 && err == io.EOF {
+		_, err := io.ReadFull(reader, szBytes)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			return retValid, nil
-		} else if err == io.ErrUnexpectedEOF {
-			return ret, err
+		} else if err != nil {
+			return retValid, err
 		}
 
-		ret += int64(n)
-
 		sz := binary.LittleEndian.Uint32(szBytes)
-		if int64(sz) > sizeLimit {
-			return -1, fmt.Errorf("Content size if above %d", sizeLimit)
+		limit := stat.Size() - retValid - int64(len(szBytes))
+		if sizeLimit > 0 && sizeLimit < limit {
+			limit = sizeLimit
+		}
+
+		if int64(sz) > limit {
+			//Garbage size prefix, do not trust it with an allocation.
+			log.Warnf("Entry size %d at offset %d is above %d, ignoring the rest of the file.", sz, retValid, limit)
+			return retValid, nil
 		}
 
 		cnt := make([]byte, sz)
-		n, err = io.ReadFull(file, cnt)
-		if n == 0 && err == io.EOF {
-			ret += int64(n)
-			return ret, nil
-		} else if err == io.ErrUnexpectedEOF {
+		_, err = io.ReadFull(reader, cnt)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return retValid, nil
+		} else if err != nil {
+			return retValid, err
+		}
+
+		if !walEntryCrcOk(cnt) {
+			log.Warnf("Entry at offset %d fails the crc check, ignoring the rest of the file.", retValid)
 			return retValid, nil
 		}
 
-		ret += int64(n)
-		retValid = ret
+		retValid += int64(len(szBytes)) + int64(sz)
 	}
 }
+
+//walEntryCrcOk checks the crc stored in the last 4 bytes of an encoded WalExRecord.
+func walEntryCrcOk(b []byte) bool {
+	if len(b) < 4 {
+		return false
+	}
+
+	crc, err := Crc32(b[:len(b)-4])
+	return err == nil && crc == binary.LittleEndian.Uint32(b[len(b)-4:])
+}
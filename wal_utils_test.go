@@ -7,6 +7,12 @@ import (
 	"testing"
 )
 
+//validWalEntry returns an encoded WalExRecord of 40 bytes.
+func validWalEntry() []byte {
+	b, _ := NewWalExRecord(&WalRecord{Key: "k", Value: make([]byte, 11)}, 1, 1).Bytes()
+	return b
+}
+
 func TestMoveToLastValidWalEntryOk(t *testing.T) {
 
 	tmpFile := fmt.Sprint(os.TempDir(), string(os.PathSeparator), "test_file.bin")
@@ -29,7 +35,7 @@ func TestMoveToLastValidWalEntryOk(t *testing.T) {
 		return
 	}
 
-	cnt := make([]byte, size)
+	cnt := validWalEntry()
 	n, err = file.Write(cnt)
 	if uint32(n) < size || err != nil {
 		t.Error("Failed to write to file: ", err)
@@ -82,7 +88,7 @@ func TestMoveToLastValidWalEntryNotOk(t *testing.T) {
 		return
 	}
 
-	cnt := make([]byte, size)
+	cnt := validWalEntry()
 	n, err = file.Write(cnt)
 	if uint32(n) < size || err != nil {
 		t.Error("Failed to write to file: ", err)
@@ -125,3 +131,89 @@ func TestMoveToLastValidWalEntryNotOk(t *testing.T) {
 
 	t.Log("Offset: ", offset)
 }
+
+func TestMoveToLastValidWalEntryGarbageSize(t *testing.T) {
+
+	tmpFile := fmt.Sprint(os.TempDir(), string(os.PathSeparator), "test_file_garbage.bin")
+	defer os.Remove(tmpFile)
+
+	szBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(szBytes, 40)
+
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		t.Error("Failed to open file: ", err)
+		return
+	}
+
+	file.Write(szBytes)
+	file.Write(validWalEntry())
+
+	//A corrupted size prefix that would need a 4GB buffer.
+	binary.LittleEndian.PutUint32(szBytes, 0xFFFFFFFF)
+	file.Write(szBytes)
+	file.Write(make([]byte, 100))
+	file.Close()
+
+	file, err = os.Open(tmpFile)
+	if err != nil {
+		t.Error("Failed to open file: ", err)
+		return
+	}
+	defer file.Close()
+
+	for _, limit := range []int64{1000, 0} {
+		offset, err := MoveToLastValidWalEntry(file, limit)
+		if err != nil {
+			t.Error("Failed to read file: ", err)
+			return
+		}
+
+		if offset != 44 {
+			t.Error("Should have returned 44 with limit: ", limit, " but found: ", offset)
+		}
+	}
+}
+
+func TestMoveToLastValidWalEntryWrongCrc(t *testing.T) {
+
+	tmpFile := fmt.Sprint(os.TempDir(), string(os.PathSeparator), "test_file_crc.bin")
+	defer os.Remove(tmpFile)
+
+	szBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(szBytes, 40)
+
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		t.Error("Failed to open file: ", err)
+		return
+	}
+
+	file.Write(szBytes)
+	file.Write(validWalEntry())
+
+	//Complete but corrupted entry followed by a torn size prefix.
+	corrupted := validWalEntry()
+	corrupted[20] ^= 0xFF
+	file.Write(szBytes)
+	file.Write(corrupted)
+	file.Write(szBytes[:2])
+	file.Close()
+
+	file, err = os.Open(tmpFile)
+	if err != nil {
+		t.Error("Failed to open file: ", err)
+		return
+	}
+	defer file.Close()
+
+	offset, err := MoveToLastValidWalEntry(file, 1000)
+	if err != nil {
+		t.Error("Failed to read file: ", err)
+		return
+	}
+
+	if offset != 44 {
+		t.Error("Should have returned 44 but found: ", offset)
+	}
+}
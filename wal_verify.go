@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//WalVerifyIssue a problem found while verifying a partition. Severe issues mean the data can not be trusted.
+type WalVerifyIssue struct {
+	Segment  string
+	Position int64
+	Offset   int64
+	Severe   bool
+	Message  string
+}
+
+//WalVerifyReport the outcome of verifying a partition.
+type WalVerifyReport struct {
+	Topic     string
+	Partition uint32
+	Segments  int
+	Records   int64
+	Bytes     int64
+	Issues    []WalVerifyIssue
+}
+
+//Failed returns true if any severe issue was found.
+func (r *WalVerifyReport) Failed() bool {
+	for _, i := range r.Issues {
+		if i.Severe {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *WalVerifyReport) add(segment string, position int64, offset int64, severe bool, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, WalVerifyIssue{
+		Segment:  segment,
+		Position: position,
+		Offset:   offset,
+		Severe:   severe,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+//VerifyPartition reads every segment of the partition the way the server does and checks crcs, sequences, timestamps,
+// segment indexes and trailing garbage. Offsets skipped by a compacted segment index are not an issue. Nothing gets modified.
+func VerifyPartition(topicDir Path, topic string, partition uint32, maxEntrySize int64) (*WalVerifyReport, error) {
+	ret := &WalVerifyReport{
+		Topic:     topic,
+		Partition: partition,
+	}
+
+	partitionDir := topicDir.AddUint32(partition)
+	segments, err := ListWalSegments(partitionDir.String())
+	if err != nil {
+		return nil, err
+	}
+
+	//Retention deletes whole segments from the front, the first one left holds the log start offset.
+	var offset int64
+	if len(segments) > 0 {
+		idx, err := ReadSegmentIndex(partitionDir.Add(segments[0]).String())
+		if err == nil {
+			offset = idx.BaseOffset
+		}
+	}
+
+	var prevSequence uint64
+	var prevOffset int64
+	var prevTimestamp int64
+	first := true
+
+	for _, segment := range segments {
+		ret.Segments++
+		segmentPath := partitionDir.Add(segment).String()
+
+		var entries []WalIndexEntry
+		idx, err := ReadSegmentIndex(segmentPath)
+		if os.IsNotExist(err) {
+			ret.add(segment, 0, offset, false, "missing index")
+		} else if err != nil {
+			ret.add(segment, 0, offset, true, "unreadable index: %v", err)
+		} else {
+			if idx.BaseOffset != offset {
+				ret.add(segment, 0, offset, true, "index base offset %d, expected %d", idx.BaseOffset, offset)
+			}
+
+			entries = idx.Entries
+		}
+
+		info, err := os.Stat(segmentPath)
+		if err != nil {
+			return nil, err
+		}
+
+		ret.Bytes += info.Size()
+		reader, err := NewWalPartitionReader(topicDir.String(), partition, segment)
+		if err != nil {
+			return nil, err
+		}
+
+		reader.MaxEntrySize = maxEntrySize
+		for {
+			position := reader.CurrentOffset
+
+			//Entries at the position either match the counted offset or skip the offsets compaction removed.
+			for len(entries) > 0 && entries[0].Position <= position {
+				if entries[0].Position == position && entries[0].Offset > offset {
+					offset = entries[0].Offset
+				} else if entries[0].Position < position || entries[0].Offset != offset {
+					ret.add(segment, position, offset, true, "index entry for offset %d points to position %d", entries[0].Offset, entries[0].Position)
+				}
+
+				entries = entries[1:]
+			}
+
+			wr, _, err := reader.ReadNextEntry()
+			if err == io.EOF {
+				break
+			} else if err != nil && err != ErrWrongChecksum {
+				ret.add(segment, position, offset, true, "trailing garbage of %d bytes: %v", info.Size()-position, err)
+				break
+			}
+
+			if err == ErrWrongChecksum {
+				ret.add(segment, position, offset, true, "crc mismatch")
+			} else {
+				//Compacted offsets take their sequences with them.
+				expected := prevSequence + uint64(offset-prevOffset)
+				if !first && wr.ID.Sequence <= prevSequence {
+					ret.add(segment, position, offset, true, "sequence %d does not follow %d", wr.ID.Sequence, prevSequence)
+				} else if !first && wr.ID.Sequence != expected {
+					ret.add(segment, position, offset, false, "sequence gap from %d to %d", prevSequence, wr.ID.Sequence)
+				}
+
+				if !first && wr.ID.Timestamp < prevTimestamp {
+					ret.add(segment, position, offset, false, "timestamp %d before previous %d", wr.ID.Timestamp, prevTimestamp)
+				}
+
+				prevSequence = wr.ID.Sequence
+				prevOffset = offset
+				prevTimestamp = wr.ID.Timestamp
+				first = false
+			}
+
+			ret.Records++
+			offset++
+		}
+
+		reader.Close()
+		for _, e := range entries {
+			ret.add(segment, e.Position, e.Offset, true, "index entry past the end of the segment")
+		}
+	}
+
+	return ret, nil
+}
+
+//runVerify implements the verify subcommand. Returns 0 when everything checks out, 1 on severe issues and 2 when
+// verification could not run.
+func runVerify(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.SetOutput(out)
+	dir := flags.String("dir", "data", "Data directory holding the topics.")
+	topic := flags.String("topic", "", "Topic to verify, all topics in the manifest when empty.")
+	maxEntrySize := flags.Int64("max-entry-size", 0, "Entries above this size are garbage, defaults to the topic max record size.")
+	quiet := flags.Bool("quiet", false, "Only print severe issues and the summary.")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	log.SetLevel(log.WarnLevel)
+	configs, err := ReadTopicsManifest(Path(*dir))
+	if err != nil {
+		fmt.Fprintln(out, "Failed to read topics: ", err)
+		return 2
+	}
+
+	ret := 0
+	found := false
+	for _, tc := range configs {
+		if *topic != "" && tc.Name != *topic {
+			continue
+		}
+
+		found = true
+		limit := *maxEntrySize
+		if limit == 0 && tc.MaxRecordSize != nil {
+			limit = *tc.MaxRecordSize + WalExRecordOverhead
+		}
+
+		var p uint32
+		for p = 0; p < tc.PartitionCount; p++ {
+			report, err := VerifyPartition(Path(*dir).Add(tc.Name), tc.Name, p, limit)
+			if err != nil {
+				fmt.Fprintf(out, "%s/%d: verification failed: %v\n", tc.Name, p, err)
+				return 2
+			}
+
+			severe := 0
+			for _, i := range report.Issues {
+				level := "WARN"
+				if i.Severe {
+					level = "ERROR"
+					severe++
+				} else if *quiet {
+					continue
+				}
+
+				fmt.Fprintf(out, "%s %s/%d %s position %d offset %d: %s\n", level, tc.Name, p, i.Segment, i.Position, i.Offset, i.Message)
+			}
+
+			fmt.Fprintf(out, "%s/%d: %d segments, %d records, %d bytes, %d errors, %d warnings\n",
+				tc.Name, p, report.Segments, report.Records, report.Bytes, severe, len(report.Issues)-severe)
+
+			if report.Failed() {
+				ret = 1
+			}
+		}
+	}
+
+	if *topic != "" && !found {
+		fmt.Fprintln(out, "No such topic: ", *topic)
+		return 2
+	}
+
+	return ret
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyReportsCorruption(t *testing.T) {
+	dir := Path(os.TempDir()).AddInt64(time.Now().UnixNano())
+	defer os.RemoveAll(dir.String())
+
+	topics, err := NewWalTopicManager(dir, testTopicDefaults())
+	if err != nil {
+		t.Fatal("Failed to create topic manager: ", err)
+	}
+
+	twr, err := topics.CreateTopic(WalTopicConfig{Name: "Test", PartitionCount: 1})
+	if err != nil {
+		t.Fatal("Failed to create topic: ", err)
+	}
+
+	for i := 0; i < 30; i++ {
+		err := <-twr.WriteWalRecord(&WalRecord{Key: "k", Value: []byte("value")})
+		if err != nil {
+			t.Fatal("Failed to write record: ", err)
+		}
+	}
+
+	topics.Close()
+
+	out := &bytes.Buffer{}
+	if code := runVerify([]string{"-dir", dir.String()}, out); code != 0 {
+		t.Error("Expected a clean verification but found: ", code, " ", out.String())
+		return
+	}
+
+	if !strings.Contains(out.String(), "30 records") {
+		t.Error("Unexpected summary: ", out.String())
+		return
+	}
+
+	segments, _ := ListWalSegments(dir.Add("Test").AddUint32(0).String())
+	segment := dir.Add("Test").AddUint32(0).Add(segments[0]).String()
+	b, _ := ioutil.ReadFile(segment)
+	b[50] ^= 0xFF
+	b = append(b, 7, 7)
+	ioutil.WriteFile(segment, b, 0644)
+
+	out.Reset()
+	if code := runVerify([]string{"-dir", dir.String(), "-topic", "Test"}, out); code != 1 {
+		t.Error("Expected the verification to fail but found: ", code, " ", out.String())
+		return
+	}
+
+	if !strings.Contains(out.String(), "crc mismatch") || !strings.Contains(out.String(), "trailing garbage of 2 bytes") {
+		t.Error("Expected the corruption to be reported: ", out.String())
+	}
+}